@@ -141,6 +141,7 @@ func NewServer(conf config.Config, info app.ServerInfo, logger *Logger.Logger) (
 
 	if s.config.Subscriber.Enabled {
 		s.SubscriberManager = coordinator.NewSubscriberManager(s.config.Subscriber, s.MetaClient, s.httpService.Handler.Logger)
+		s.SubscriberManager.SetLocalAddress(c.HTTP.BindAddress)
 	}
 	config.SetSubscriptionEnable(s.config.Subscriber.Enabled)
 
@@ -217,7 +218,7 @@ func (s *Server) initQueryExecutor(c *config.TSSql) {
 	metaExecutor.SetTimeOut(time.Duration(c.Coordinator.MetaExecutorWriteTimeout))
 
 	s.QueryExecutor = query.NewExecutor(cpu.GetCpuNum())
-	s.QueryExecutor.StatementExecutor = &coordinator2.StatementExecutor{
+	statementExecutor := &coordinator2.StatementExecutor{
 		MetaClient:  s.MetaClient,
 		TaskManager: s.QueryExecutor.TaskManager,
 		NetStorage:  s.TSDBStore,
@@ -235,6 +236,10 @@ func (s *Server) initQueryExecutor(c *config.TSSql) {
 		Hostname:                config.CombineDomain(s.config.HTTP.Domain, s.config.HTTP.BindAddress),
 		SqlConfigs:              c.ShowConfigs(),
 	}
+	if s.SubscriberManager != nil {
+		statementExecutor.StatementForwarder = s.SubscriberManager
+	}
+	s.QueryExecutor.StatementExecutor = statementExecutor
 	s.QueryExecutor.TaskManager.QueryTimeout = time.Duration(c.Coordinator.QueryTimeout)
 	s.QueryExecutor.TaskManager.LogQueriesAfter = time.Duration(c.Coordinator.LogQueriesAfter)
 	s.QueryExecutor.TaskManager.MaxConcurrentQueries = c.Coordinator.MaxConcurrentQueries
@@ -298,8 +303,7 @@ func (s *Server) Open() error {
 	s.httpService.Handler.PointsWriter = s.PointsWriter
 	if s.SubscriberManager != nil {
 		s.httpService.Handler.SubscriberManager = s.SubscriberManager
-		s.SubscriberManager.InitWriters()
-		go s.SubscriberManager.Update()
+		s.SubscriberManager.Start(context.Background())
 	}
 
 	if err := s.castorService.Open(); err != nil {
@@ -393,7 +397,7 @@ func (s *Server) Close() error {
 	}
 
 	if s.SubscriberManager != nil {
-		s.SubscriberManager.StopAllWriters()
+		s.SubscriberManager.Stop()
 	}
 
 	if s.sherlockService != nil {
@@ -457,6 +461,7 @@ func (s *Server) initStatisticsPusher() {
 	stat.NewMetaStatistics().Init(globalTags)
 	stat.InitExecutorStatistics(globalTags)
 	stat.NewErrnoStat().Init(globalTags)
+	stat.InitSubscriberStatistics(globalTags)
 
 	s.statisticsPusher.Register(
 		stat.CollectHandlerStatistics,
@@ -467,6 +472,10 @@ func (s *Server) initStatisticsPusher() {
 		stat.NewErrnoStat().Collect,
 	)
 
+	if s.SubscriberManager != nil {
+		s.statisticsPusher.Register(s.SubscriberManager.CollectStatistics)
+	}
+
 	s.statisticsPusher.RegisterOps(stat.CollectOpsHandlerStatistics)
 	s.statisticsPusher.RegisterOps(stat.CollectOpsSpdyStatistics)
 	s.statisticsPusher.RegisterOps(stat.CollectOpsSqlSlowQueryStatistics)
@@ -18,350 +18,2365 @@ package coordinator
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/openGemini/openGemini/lib/config"
 	"github.com/openGemini/openGemini/lib/crypto"
 	"github.com/openGemini/openGemini/lib/logger"
+	"github.com/openGemini/openGemini/lib/statisticsPusher/statistics"
+	"github.com/openGemini/openGemini/open_src/influx/influxql"
 	"github.com/openGemini/openGemini/open_src/influx/meta"
 	"go.uber.org/zap"
 )
 
 type Client interface {
-	Send(db, rp string, lineProtocol []byte) error
+	Send(ctx context.Context, db, rp, user string, lineProtocol []byte) error
+	// Query runs the statement q on database db of the destination, e.g. to mirror a DROP MEASUREMENT
+	Query(ctx context.Context, db, q string) error
+	Ping() error
 	Destination() string
+	Stats() *statistics.SubscriberStats
+}
+
+// destinationParams are the query parameters of a destination configuring the client, they are never secret
+var destinationParams = map[string]bool{"rp": true, "sni": true, "maxconc": true, "method": true, "delay": true, "precision": true}
+
+// redactDestination returns dest with its password and the values of the query parameters other than
+// destinationParams replaced, e.g. a token of a webhook, for showing it to the observers and in the logs
+func redactDestination(dest string) string {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return dest
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		redacted := false
+		for name, values := range query {
+			if destinationParams[name] {
+				continue
+			}
+			for i := range values {
+				values[i] = "xxxxx"
+			}
+			redacted = true
+		}
+		if redacted {
+			u.RawQuery = query.Encode()
+		}
+	}
+	return u.Redacted()
+}
+
+// DestinationOverrides redirects the traffic of destinations to other destinations at runtime,
+// e.g. to a standby while the primary destination is under maintenance
+type DestinationOverrides struct {
+	mu        sync.RWMutex
+	overrides map[string]*url.URL
+}
+
+func NewDestinationOverrides() *DestinationOverrides {
+	return &DestinationOverrides{overrides: make(map[string]*url.URL)}
+}
+
+// Get returns the destination that the traffic of dest is redirected to, or nil if it is not redirected
+func (o *DestinationOverrides) Get(dest string) *url.URL {
+	if o == nil {
+		return nil
+	}
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.overrides[dest]
+}
+
+func (o *DestinationOverrides) Set(dest string, override *url.URL) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.overrides[dest] = override
+}
+
+func (o *DestinationOverrides) Delete(dest string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.overrides, dest)
 }
 
 type HTTPClient struct {
 	client *http.Client
 	url    *url.URL
+	// userHeader is the header used to forward the user of the original write,
+	// the user is not forwarded if it is empty
+	userHeader string
+	// nodeIDHeader is the header used to send nodeID, the id of this node, with every write,
+	// nodeID is not sent if the header is empty
+	nodeIDHeader string
+	nodeID       string
+	overrides    *DestinationOverrides
+	// rps override the retention policy of the forwarded writes if it is not empty,
+	// they are specified by the rp query parameter of the destination, e.g. http://127.0.0.1:8086?rp=longterm.
+	// a write is forwarded once per rp if there are several, e.g. http://127.0.0.1:8086?rp=raw,downsampled
+	rps         []string
+	contentType string
+	gzip        bool
+	// gzipMinSize is the size below which the writes are sent uncompressed even if gzip is set
+	gzipMinSize int
+	stats       *statistics.SubscriberStats
+	// sem limits the concurrent in-flight requests to the destination, nil means no limit
+	sem chan struct{}
+	// createQuery is run on the destination when a write returns 404, then the write is retried once.
+	// {db} and {rp} are replaced by the quoted database and retention policy, empty disables it
+	createQuery string
+	// precision is specified by the precision query parameter of the destination, e.g. http://127.0.0.1:8086?precision=s.
+	// the timestamps of the writes are rescaled from their precision to it, empty rescales them to nanoseconds.
+	// tsUnit is the number of nanoseconds in a unit of precision
+	precision string
+	tsUnit    int64
+	// method is the http method of the writes, empty means POST
+	method string
+	// tooLarge is the size of the writes the destination accepted after it rejected a larger one with 413
+	tooLarge sizeLimit
+	// maxPoints is the maximum number of lines of each request, a larger write is sent in several requests.
+	// zero means no limit
+	maxPoints int
+	// headers are the static headers of the destination sent with every request
+	headers map[string]string
+	// delay is slept before each write to simulate a slow network, it is only set if allow-test-delay is
+	delay time.Duration
+}
+
+// setHeaders sets the static headers of a destination on req
+func setHeaders(req *http.Request, headers map[string]string) {
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+}
+
+// get sends a GET request with the static headers to path of the destination
+func (c *HTTPClient) get(path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, c.endpoint(path), nil)
+	if err != nil {
+		return nil, err
+	}
+	setHeaders(req, c.headers)
+	return c.client.Do(req)
+}
+
+// sizeLimit is a size limit that expires after a cooldown since it is last lowered
+type sizeLimit struct {
+	lock     sync.Mutex
+	size     int
+	until    time.Time
+	cooldown time.Duration
+}
+
+// get returns the size limit, zero if there is no limit
+func (l *sizeLimit) get() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.size > 0 && time.Now().After(l.until) {
+		l.size = 0
+	}
+	return l.size
+}
+
+// lower limits the size to size if it is below the current limit, the limit is kept for the cooldown
+func (l *sizeLimit) lower(size int) {
+	if l.cooldown <= 0 {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.size == 0 || size < l.size || time.Now().After(l.until) {
+		l.size = size
+	}
+	l.until = time.Now().Add(l.cooldown)
+}
+
+// splitLines splits lineProtocol into two halves at the line boundary nearest to the middle,
+// ok is false if it is a single line
+func splitLines(lineProtocol []byte) (first, second []byte, ok bool) {
+	trimmed := bytes.TrimRight(lineProtocol, "\n")
+	mid := len(trimmed) / 2
+	i := bytes.IndexByte(trimmed[mid:], '\n')
+	if i >= 0 {
+		i += mid
+	} else {
+		i = bytes.LastIndexByte(trimmed[:mid], '\n')
+	}
+	if i < 0 {
+		return nil, nil, false
+	}
+	return trimmed[:i+1], trimmed[i+1:], true
+}
+
+// cutLines cuts the first n lines from lineProtocol, ok is false if it has no more than n lines
+func cutLines(lineProtocol []byte, n int) (head, tail []byte, ok bool) {
+	end := 0
+	for i := 0; i < n; i++ {
+		j := bytes.IndexByte(lineProtocol[end:], '\n')
+		if j < 0 {
+			return lineProtocol, nil, false
+		}
+		end += j + 1
+	}
+	if len(bytes.TrimSpace(lineProtocol[end:])) == 0 {
+		return lineProtocol, nil, false
+	}
+	return lineProtocol[:end], lineProtocol[end:], true
+}
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// gzipStream returns a reader of the gzip compressed data, the data is compressed incrementally
+// as the reader is consumed, so the compressed body is never buffered as a whole.
+// wire is called with the compressed size once the data is compressed
+func gzipStream(data []byte, wire func(n int64)) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		gz, _ := gzipWriterPool.Get().(*gzip.Writer)
+		defer gzipWriterPool.Put(gz)
+		cw := &countingWriter{w: pw}
+		gz.Reset(cw)
+		_, err := gz.Write(data)
+		if err == nil {
+			err = gz.Close()
+		}
+		if err == nil {
+			wire(cw.n)
+		}
+		// the reader is closed by the transport if the request ends before the body is consumed
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// endpoint returns the url of path that the requests are sent to,
+// it respects the destination overrides and drops the query parameters of the destination
+func (c *HTTPClient) endpoint(path string) string {
+	u := *c.url
+	if o := c.overrides.Get(c.Destination()); o != nil {
+		u = *o
+	}
+	u.Path += path
+	u.RawQuery = ""
+	return u.String()
+}
+
+// targetRPs returns the retention policies specified by the rp query parameters of the destination,
+// both repeated parameters and comma separated values are accepted
+func targetRPs(u *url.URL) []string {
+	var rps []string
+	for _, v := range u.Query()["rp"] {
+		for _, rp := range strings.Split(v, ",") {
+			if rp != "" {
+				rps = append(rps, rp)
+			}
+		}
+	}
+	return rps
+}
+
+// precisionUnit returns the number of nanoseconds in a unit of precision
+func precisionUnit(precision string) (int64, bool) {
+	switch precision {
+	case "ns", "n":
+		return 1, true
+	case "u", "us", "µ":
+		return 1e3, true
+	case "ms":
+		return 1e6, true
+	case "s":
+		return 1e9, true
+	case "m":
+		return 1e9 * 60, true
+	case "h":
+		return 1e9 * 3600, true
+	}
+	return 0, false
+}
+
+// timestampUnit returns the number of nanoseconds in a unit of the precision of a write request,
+// an empty or unknown precision means nanoseconds like it does for the http write handler
+func timestampUnit(precision string) int64 {
+	if unit, ok := precisionUnit(precision); ok {
+		return unit
+	}
+	return 1
+}
+
+// setPrecision makes the writes to the destination use precision, the timestamps are rescaled accordingly
+func (c *HTTPClient) setPrecision(precision string) error {
+	unit, ok := precisionUnit(precision)
+	if !ok {
+		return fmt.Errorf("unknown precision %s", precision)
+	}
+	c.precision = precision
+	c.tsUnit = unit
+	return nil
+}
+
+// lineTimestamp returns the timestamp of a line of line protocol and its offset in the line,
+// ok is false if the line has no timestamp
+func lineTimestamp(line []byte) (ts int64, offset int, ok bool) {
+	trimmed := bytes.TrimRight(line, " \r")
+	if bytes.HasPrefix(trimmed, []byte("#")) {
+		return 0, 0, false
+	}
+	// the timestamp is the last section of the line, a field set never ends with a bare integer
+	i := bytes.LastIndexByte(trimmed, ' ')
+	if i <= 0 {
+		return 0, 0, false
+	}
+	ts, err := strconv.ParseInt(string(trimmed[i+1:]), 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return ts, i + 1, true
+}
+
+// rescaleTimestamps rescales the timestamps of the lines from the unit from to the unit to, both in nanoseconds,
+// the lines without timestamp are kept as they are. the units of the precisions are multiples of each other
+func rescaleTimestamps(lineProtocol []byte, from, to int64) []byte {
+	if from == to {
+		return lineProtocol
+	}
+	buf := make([]byte, 0, len(lineProtocol))
+	for len(lineProtocol) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(lineProtocol, '\n'); i >= 0 {
+			line, lineProtocol = lineProtocol[:i], lineProtocol[i+1:]
+		} else {
+			line, lineProtocol = lineProtocol, nil
+		}
+		if ts, i, ok := lineTimestamp(line); ok {
+			if from > to {
+				ts *= from / to
+			} else {
+				ts /= to / from
+			}
+			buf = append(buf, line[:i]...)
+			buf = strconv.AppendInt(buf, ts, 10)
+			buf = append(buf, line[len(bytes.TrimRight(line, " \r")):]...)
+			buf = append(buf, '\n')
+			continue
+		}
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+	return buf
+}
+
+// setMaxConcurrency limits the concurrent in-flight requests to the destination to n, zero means no limit
+func (c *HTTPClient) setMaxConcurrency(n int) {
+	if n > 0 {
+		c.sem = make(chan struct{}, n)
+	}
+}
+
+// precisionSender is implemented by the clients that rescale the timestamps of the writes themselves,
+// the other clients are sent the writes with nanosecond timestamps
+type precisionSender interface {
+	SendPrecision(ctx context.Context, db, rp, user, precision string, lineProtocol []byte) error
+}
+
+func (c *HTTPClient) Send(ctx context.Context, db, rp, user string, lineProtocol []byte) error {
+	return c.SendPrecision(ctx, db, rp, user, "", lineProtocol)
+}
+
+// SendPrecision sends lineProtocol whose timestamps are in precision, empty for nanoseconds,
+// the timestamps are rescaled to the precision of the destination
+func (c *HTTPClient) SendPrecision(ctx context.Context, db, rp, user, precision string, lineProtocol []byte) error {
+	if c.delay > 0 {
+		timer := time.NewTimer(c.delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	if c.sem != nil {
+		select {
+		case c.sem <- struct{}{}:
+			defer func() { <-c.sem }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	to := int64(1)
+	if c.precision != "" {
+		to = c.tsUnit
+	}
+	lineProtocol = rescaleTimestamps(lineProtocol, timestampUnit(precision), to)
+	if len(c.rps) == 0 {
+		return c.writePoints(ctx, db, rp, user, lineProtocol)
+	}
+	for _, rp := range c.rps {
+		if err := c.writePoints(ctx, db, rp, user, lineProtocol); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePoints writes lineProtocol in requests of at most maxPoints lines
+func (c *HTTPClient) writePoints(ctx context.Context, db, rp, user string, lineProtocol []byte) error {
+	for c.maxPoints > 0 {
+		head, tail, ok := cutLines(lineProtocol, c.maxPoints)
+		if !ok {
+			break
+		}
+		if err := c.write(ctx, db, rp, user, head); err != nil {
+			return err
+		}
+		lineProtocol = tail
+	}
+	return c.write(ctx, db, rp, user, lineProtocol)
+}
+
+// compressed reports whether lineProtocol is sent gzip compressed, a small payload does not pay for the compression
+func (c *HTTPClient) compressed(lineProtocol []byte) bool {
+	return c.gzip && len(lineProtocol) >= c.gzipMinSize
+}
+
+// newBody returns the request body of lineProtocol, it can be called again to re-create the body
+func (c *HTTPClient) newBody(lineProtocol []byte) io.ReadCloser {
+	if !c.compressed(lineProtocol) {
+		return ioutil.NopCloser(bytes.NewReader(lineProtocol))
+	}
+	return gzipStream(lineProtocol, func(n int64) {
+		c.stats.AddBytes(0, n)
+	})
+}
+
+// write sends lineProtocol to db.rp of the destination, a gzip compressed body is streamed
+// with chunked encoding instead of being buffered.
+// a write rejected with 413 is split in halves, which are written in turn, and the writes to the
+// destination are split in advance to the size of the halves until the too large cooldown expires
+func (c *HTTPClient) write(ctx context.Context, db, rp, user string, lineProtocol []byte) error {
+	if limit := c.tooLarge.get(); limit > 0 && len(lineProtocol) > limit {
+		if first, second, ok := splitLines(lineProtocol); ok {
+			return c.writeHalves(ctx, db, rp, user, first, second)
+		}
+	}
+	status, err := c.post(ctx, db, rp, user, lineProtocol)
+	switch {
+	case status == http.StatusRequestEntityTooLarge:
+		first, second, ok := splitLines(lineProtocol)
+		if !ok {
+			return err
+		}
+		c.tooLarge.lower(len(lineProtocol) / 2)
+		return c.writeHalves(ctx, db, rp, user, first, second)
+	case status == http.StatusNotFound && c.createQuery != "":
+		// the database or retention policy does not exist on the destination, create it and retry once
+		if err := c.create(ctx, db, rp); err != nil {
+			return fmt.Errorf("fail to create %s.%s on not found: %v", db, rp, err)
+		}
+		_, err = c.post(ctx, db, rp, user, lineProtocol)
+	}
+	return err
+}
+
+func (c *HTTPClient) writeHalves(ctx context.Context, db, rp, user string, first, second []byte) error {
+	if err := c.write(ctx, db, rp, user, first); err != nil {
+		return err
+	}
+	return c.write(ctx, db, rp, user, second)
+}
+
+// create runs the create query of db.rp on the destination
+func (c *HTTPClient) create(ctx context.Context, db, rp string) error {
+	q := strings.NewReplacer("{db}", influxql.QuoteIdent(db), "{rp}", influxql.QuoteIdent(rp)).Replace(c.createQuery)
+	return c.Query(ctx, "", q)
 }
 
-func (c *HTTPClient) Send(db, rp string, lineProtocol []byte) error {
-	r := bytes.NewReader(lineProtocol)
-	req, err := http.NewRequest("POST", c.url.String()+"/write", r)
+func (c *HTTPClient) Query(ctx context.Context, db, q string) error {
+	form := url.Values{"q": []string{q}}
+	if db != "" {
+		form.Set("db", db)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint("/query"), strings.NewReader(form.Encode()))
 	if err != nil {
 		return err
 	}
+	setHeaders(req, c.headers)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf(string(body))
+	}
+	return nil
+}
+
+// post sends the write request and returns the response status, zero if there is no response
+func (c *HTTPClient) post(ctx context.Context, db, rp, user string, lineProtocol []byte) (int, error) {
+	method := c.method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint("/write"), nil)
+	if err != nil {
+		return 0, err
+	}
+	setHeaders(req, c.headers)
+	body := lineProtocol
+	if c.contentType == config.MsgpackContentType {
+		if body, err = EncodeLineProtocolMsgpack(nil, lineProtocol); err != nil {
+			return 0, fmt.Errorf("fail to encode write with msgpack: %v", err)
+		}
+	}
+	req.Body = c.newBody(body)
+	req.GetBody = func() (io.ReadCloser, error) {
+		return c.newBody(body), nil
+	}
+	compressed := c.compressed(body)
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	} else {
+		req.ContentLength = int64(len(body))
+	}
+	if c.contentType != "" {
+		req.Header.Set("Content-Type", c.contentType)
+	}
+	if c.userHeader != "" && user != "" {
+		req.Header.Set(c.userHeader, user)
+	}
+	if c.nodeIDHeader != "" {
+		req.Header.Set(c.nodeIDHeader, c.nodeID)
+	}
 
 	params := req.URL.Query()
 	params.Set("db", db)
 	params.Set("rp", rp)
+	if c.precision != "" {
+		params.Set("precision", c.precision)
+	}
 	req.URL.RawQuery = params.Encode()
 
 	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if compressed {
+		// the wire bytes are counted as the body is compressed
+		c.stats.AddBytes(int64(len(lineProtocol)), 0)
+	} else {
+		c.stats.AddBytes(int64(len(lineProtocol)), int64(len(body)))
+	}
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return resp.StatusCode, err
+		}
+		err = fmt.Errorf(string(body))
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, nil
+}
+
+func (c *HTTPClient) Ping() error {
+	resp, err := c.get("/ping")
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		body, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected ping status %s", resp.Status)
+	}
+	return nil
+}
+
+// CloseIdleConnections closes the keep-alive connections to the destination, e.g. when the writer is idle
+func (c *HTTPClient) CloseIdleConnections() {
+	c.client.CloseIdleConnections()
+}
+
+func (c *HTTPClient) Destination() string {
+	return c.url.String()
+}
+
+func (c *HTTPClient) Stats() *statistics.SubscriberStats {
+	return c.stats
+}
+
+// newTransport returns a transport that reaches the destinations through proxy,
+// the HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables are honored if proxy is nil
+func newTransport(proxy *url.URL) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	} else {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+	return transport
+}
+
+// lifetimeTransport closes the idle connections once every lifetime, so that the keep-alive connections
+// do not pin to a single backend behind a load balancer and the traffic is redistributed periodically
+type lifetimeTransport struct {
+	*http.Transport
+	lifetime time.Duration
+	recycled int64 // unix nano of the last time the connections were recycled
+}
+
+func newLifetimeTransport(transport *http.Transport, lifetime time.Duration) *lifetimeTransport {
+	return &lifetimeTransport{Transport: transport, lifetime: lifetime, recycled: time.Now().UnixNano()}
+}
+
+func (t *lifetimeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&t.recycled)
+	if now-last >= int64(t.lifetime) && atomic.CompareAndSwapInt64(&t.recycled, last, now) {
+		t.Transport.CloseIdleConnections()
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+// setLocalAddr binds the connections to the destination to the local ip, it must be called
+// before the transport is wrapped, e.g. by setConnMaxLifetime
+func (c *HTTPClient) setLocalAddr(ip net.IP) {
+	transport, ok := c.client.Transport.(*http.Transport)
+	if !ok {
+		return
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		LocalAddr: &net.TCPAddr{IP: ip},
+	}
+	transport.DialContext = dialer.DialContext
+}
+
+// setConnMaxLifetime makes the client recycle its connections after lifetime, zero means no limit
+func (c *HTTPClient) setConnMaxLifetime(lifetime time.Duration) {
+	if lifetime <= 0 {
+		return
+	}
+	if transport, ok := c.client.Transport.(*http.Transport); ok {
+		c.client.Transport = newLifetimeTransport(transport, lifetime)
+	}
+}
+
+func NewHTTPClient(url *url.URL, timeout time.Duration, proxy *url.URL) *HTTPClient {
+	c := &http.Client{Timeout: timeout, Transport: newTransport(proxy)}
+	return &HTTPClient{client: c, url: url, rps: targetRPs(url), stats: statistics.NewSubscriberStats()}
+}
+
+func NewHTTPSClient(url *url.URL, timeout time.Duration, skipVerify bool, certs string, proxy *url.URL) (*HTTPClient, error) {
+	var tlsConfig *tls.Config
+
+	if certs == "" {
+		tlsConfig = &tls.Config{
+			InsecureSkipVerify: true,
+		}
+	} else {
+		cert, err := tls.X509KeyPair(
+			[]byte(crypto.DecryptFromFile(certs)),
+			[]byte(crypto.DecryptFromFile(certs)),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig = &tls.Config{
+			InsecureSkipVerify: skipVerify,
+			Certificates:       []tls.Certificate{cert},
+		}
+	}
+
+	// the sni query parameter of the destination overrides the server name used to verify the certificate,
+	// e.g. https://10.0.0.1:8086?sni=db.example.com
+	tlsConfig.ServerName = url.Query().Get("sni")
+
+	transport := newTransport(proxy)
+	transport.TLSClientConfig = tlsConfig
+	c := &http.Client{Timeout: timeout, Transport: transport}
+	return &HTTPClient{client: c, url: url, rps: targetRPs(url), stats: statistics.NewSubscriberStats()}, nil
+}
+
+type WriteRequest struct {
+	Client       int
+	User         string
+	LineProtocol []byte
+	// Precision is the precision of the timestamps of LineProtocol, empty for nanoseconds
+	Precision string
+	// Statement is forwarded instead of LineProtocol if it is not empty
+	Statement string
+	// FanOut indicates that the write request is forwarded to all the clients instead of Client
+	FanOut bool
+	// Track indicates that the measurements of LineProtocol are tracked by the worker taking the write request,
+	// a write sent to several clients in separate write requests is only tracked by one of them
+	Track bool
+}
+
+type BaseWriter struct {
+	ch      chan *WriteRequest
+	clients []Client
+	filter  *KeyFilter
+	sampler *Sampler
+	// predicate drops the points not matching it, nil forwards all the points
+	predicate *Predicate
+	// recent tracks the measurements forwarded, nil if the tracking is disabled
+	recent *RecentMeasurements
+	// maxAge drops the points older than it relative to now, zero keeps the points of any age
+	maxAge time.Duration
+	// maxAgeSkew widens maxAge by the tolerated clock skew between the nodes
+	maxAgeSkew time.Duration
+
+	sStats *statistics.SubscriptionStats
+	db     string
+	rp     string
+	name   string
+	// logger carries the db, rp, subscription and mode of the writer
+	logger *logger.Logger
+	// sendTimeout bounds the time a worker spends on a single write request,
+	// so that a slow destination can not occupy the workers forever, zero means no limit
+	sendTimeout time.Duration
+	// fullTimeout is the maximum time to wait for a full buffer, zero means the request is dropped immediately
+	fullTimeout time.Duration
+	// slowEnqueue is the wait for a full buffer above which a warning is logged, zero disables it
+	slowEnqueue time.Duration
+	wg          *sync.WaitGroup
+	// concurrency is the number of workers started, workers is the number of them still running
+	concurrency int
+	workers     int32
+	bufferSize  int
+	// closeOnce closes the clients once, either by the last worker after Stop or by Stop if the writer is idle
+	closeOnce *sync.Once
+	// stopLock keeps Stop from closing ch while a write request is being sent to it,
+	// stopped is set under it when ch is closed, the write requests sent after that are dropped
+	stopLock *sync.RWMutex
+	stopped  bool
+	// started is set under stopLock by the first Start, the later ones are ignored
+	started bool
+	// idle is set under stopLock when the workers are stopped as no write request is sent for idleTimeout,
+	// the next write request starts them again. zero idleTimeout keeps the workers running.
+	// lastSend is the unix nano of the last write request sent
+	idle        bool
+	idleTimeout time.Duration
+	lastSend    int64
+	idleDone    chan struct{}
+	// warmup indicates whether to ping the clients at Start, so that the connections are ready for the first write
+	warmup bool
+	// failover indicates whether a failed write request is sent to the next client instead of being given up on,
+	// it is used by ANY mode to deliver to any one live destination
+	failover bool
+	// retries caps the failover retries to a fraction of the successful writes, nil means no limit
+	retries *RetryBudget
+	// failoverBackoff is the wait before the first failover retry of an error other than a connection error,
+	// doubled on each retry, zero fails over immediately
+	failoverBackoff time.Duration
+	// weights are fed with the outcome of each write to a client, nil if the weights do not adapt
+	weights *AdaptiveWeights
+	// failures receives the write requests that are given up on, nil if no hook is interested in them
+	failures chan<- *WriteFailure
+	// observe is called with each completed write, nil if there is no write observer
+	observe func(e *WriteEvent)
+	// fanOutLimit is the maximum number of clients a FanOut write request is forwarded to concurrently
+	fanOutLimit int
+}
+
+func NewBaseWriter(db, rp, name string, clients []Client, logger *logger.Logger) BaseWriter {
+	return BaseWriter{db: db, rp: rp, name: name, clients: clients, sStats: statistics.NewSubscriptionStats(),
+		logger: logger, wg: &sync.WaitGroup{}, stopLock: &sync.RWMutex{}, closeOnce: &sync.Once{}}
+}
+
+// filterLines samples lineProtocol whose timestamps are in precision and removes the filtered keys from it,
+// ok is false if there is nothing left to forward
+func (w *BaseWriter) filterLines(precision string, lineProtocol []byte) (out []byte, ok bool) {
+	// an empty payload would cost a round trip and may be rejected by the destination
+	if len(bytes.TrimSpace(lineProtocol)) == 0 {
+		atomic.AddInt64(&w.sStats.SkippedEmpty, 1)
+		return nil, false
+	}
+	if w.maxAge > 0 {
+		var dropped int64
+		lineProtocol, dropped = dropStale(lineProtocol, time.Now().Add(-w.maxAge-w.maxAgeSkew).UnixNano(), timestampUnit(precision))
+		atomic.AddInt64(&w.sStats.DroppedStale, dropped)
+	}
+	if w.predicate != nil {
+		var unmatched int64
+		lineProtocol, unmatched = w.predicate.Filter(lineProtocol)
+		atomic.AddInt64(&w.sStats.Unmatched, unmatched)
+	}
+	if w.sampler != nil {
+		lineProtocol = w.sampler.Sample(lineProtocol)
+	}
+	out = lineProtocol
+	if w.filter != nil {
+		var res FilterResult
+		var err error
+		out, res, err = w.filter.Filter(lineProtocol)
+		if err != nil {
+			w.logger.Error("failed to filter write request", zap.Error(err))
+		}
+		atomic.AddInt64(&w.sStats.RemovedTags, res.RemovedTags)
+		atomic.AddInt64(&w.sStats.RemovedFields, res.RemovedFields)
+		atomic.AddInt64(&w.sStats.DroppedPoints, res.DroppedPoints)
+	}
+	return out, len(out) > 0
+}
+
+func (w *BaseWriter) Send(wr *WriteRequest) {
+	w.stopLock.RLock()
+	for w.idle && !w.stopped {
+		w.stopLock.RUnlock()
+		w.resume()
+		w.stopLock.RLock()
+	}
+	defer w.stopLock.RUnlock()
+	if w.stopped {
+		atomic.AddInt64(&w.sStats.DroppedOnStop, 1)
+		return
+	}
+	if w.idleTimeout > 0 {
+		atomic.StoreInt64(&w.lastSend, time.Now().UnixNano())
+	}
+	select {
+	case w.ch <- wr:
+		return
+	default:
+	}
+	if w.fullTimeout <= 0 {
+		atomic.AddInt64(&w.sStats.DroppedOnFull, 1)
+		w.logger.Error("failed to send write request to write buffer", zap.String("dest", redactDestination(w.clients[wr.Client].Destination())))
+		return
+	}
+	start := time.Now()
+	defer func() {
+		wait := time.Since(start)
+		w.sStats.AddEnqueueWait(wait)
+		if w.slowEnqueue > 0 && wait > w.slowEnqueue {
+			w.logger.Warn("slow enqueue to write buffer", zap.Duration("wait", wait))
+		}
+	}()
+	timer := time.NewTimer(w.fullTimeout)
+	defer timer.Stop()
+	select {
+	case w.ch <- wr:
+	case <-timer.C:
+		atomic.AddInt64(&w.sStats.TimedOutOnFull, 1)
+		w.logger.Error("write buffer is still full after timeout, drop write request", zap.String("dest", redactDestination(w.clients[wr.Client].Destination())),
+			zap.Duration("timeout", w.fullTimeout))
+	}
+}
+
+func (w *BaseWriter) send(wr *WriteRequest) error {
+	ctx := context.Background()
+	if w.sendTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.sendTimeout)
+		defer cancel()
+	}
+	c := w.clients[wr.Client]
+	if wr.Statement != "" {
+		return c.Query(ctx, w.db, wr.Statement)
+	}
+	if ps, ok := c.(precisionSender); ok {
+		return ps.SendPrecision(ctx, w.db, w.rp, wr.User, wr.Precision, wr.LineProtocol)
+	}
+	return c.Send(ctx, w.db, w.rp, wr.User, rescaleTimestamps(wr.LineProtocol, timestampUnit(wr.Precision), 1))
+}
+
+// observedSend sends the write request and hands the outcome to the write observers
+// and counts it in the statistics of the subscription, which the metrics exporter reads, as the observers may drop it
+func (w *BaseWriter) observedSend(wr *WriteRequest) error {
+	start := time.Now()
+	err := w.send(wr)
+	d := time.Since(start)
+	w.sStats.AddWrite(d, err)
+	if w.observe != nil {
+		w.observe(&WriteEvent{Database: w.db, RetentionPolicy: w.rp, Subscription: w.name, Destination: redactDestination(w.clients[wr.Client].Destination()),
+			Bytes: len(wr.LineProtocol) + len(wr.Statement), Duration: d, Err: err})
+	}
+	return err
+}
+
+func (w *BaseWriter) Run() {
+	for wr := range w.ch {
+		// the measurements are tracked by the workers, so that the writes are not held up by the tracking
+		if wr.Track && w.recent != nil {
+			w.recent.Observe(wr.LineProtocol)
+		}
+		if wr.FanOut {
+			w.fanOut(wr)
+			continue
+		}
+		w.forward(wr)
+	}
+}
+
+// forward sends the write request to its client, and to the next clients on failure if failover is enabled,
+// it returns the error of the last attempt
+func (w *BaseWriter) forward(wr *WriteRequest) error {
+	err := w.observedSend(wr)
+	w.weights.Observe(wr.Client, err)
+	backoff := w.failoverBackoff
+	// try the next clients in rotation until one of them accepts the write request
+	for k := 1; err != nil && w.failover && k < len(w.clients) && w.retries.Withdraw(); k++ {
+		w.logger.Warn("failed to forward write request, try the next destination", zap.String("dest", redactDestination(w.clients[wr.Client].Destination())),
+			zap.Error(err))
+		// a destination that can not be connected to is down, while one that fails to answer may only be overloaded
+		if backoff > 0 && !isConnectionError(err) {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		wr.Client = (wr.Client + 1) % len(w.clients)
+		err = w.observedSend(wr)
+		w.weights.Observe(wr.Client, err)
+	}
+	if err != nil {
+		w.logger.Error("failed to forward write request", zap.String("dest", redactDestination(w.clients[wr.Client].Destination())), zap.Error(err))
+		w.reportFailure(redactDestination(w.clients[wr.Client].Destination()), err)
+		return err
+	}
+	w.retries.Deposit()
+	w.clients[wr.Client].Stats().SetLastWriteSuccess(time.Now().UnixNano())
+	return nil
+}
+
+// isConnectionError reports whether err means that the destination could not be connected to at all
+func isConnectionError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// FanOutResult is the outcome of a write request forwarded to all the destinations of an ALL mode subscription,
+// Errs[i] is the error of Destinations[i], nil if it accepted the write request
+type FanOutResult struct {
+	Destinations []string
+	Errs         []error
+}
+
+// Failed returns the number of destinations that failed the write request
+func (r *FanOutResult) Failed() int {
+	var n int
+	for _, err := range r.Errs {
+		if err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Partial reports whether some but not all of the destinations failed the write request
+func (r *FanOutResult) Partial() bool {
+	n := r.Failed()
+	return n > 0 && n < len(r.Errs)
+}
+
+// fanOut forwards the write request to all the clients, at most fanOutLimit of them concurrently,
+// and returns the outcome of each client once all of them are done
+func (w *BaseWriter) fanOut(wr *WriteRequest) *FanOutResult {
+	res := &FanOutResult{Destinations: make([]string, len(w.clients)), Errs: make([]error, len(w.clients))}
+	sem := make(chan struct{}, w.fanOutLimit)
+	var wg sync.WaitGroup
+	for i := range w.clients {
+		res.Destinations[i] = redactDestination(w.clients[i].Destination())
+		sem <- struct{}{}
+		wg.Add(1)
+		req := *wr
+		req.Client, req.FanOut = i, false
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			res.Errs[i] = w.forward(&req)
+		}(i)
+	}
+	wg.Wait()
+	switch failed := res.Failed(); {
+	case failed == len(res.Errs) && failed > 0:
+		atomic.AddInt64(&w.sStats.FailedFanOuts, 1)
+	case failed > 0:
+		atomic.AddInt64(&w.sStats.PartialFanOuts, 1)
+	}
+	return res
+}
+
+// reportFailure hands the write request given up on to the write failure hooks without blocking the worker,
+// the failure is dropped if the hooks fall behind
+func (w *BaseWriter) reportFailure(dest string, err error) {
+	if w.failures == nil {
+		return
+	}
+	select {
+	case w.failures <- &WriteFailure{Database: w.db, RetentionPolicy: w.rp, Subscription: w.name, Destination: dest, Err: err}:
+	default:
+		w.logger.Warn("write failure hooks fall behind, drop the failure", zap.String("dest", dest))
+	}
+}
+
+// CollectStatistics appends the statistics of each destination to buffer
+func (w *BaseWriter) CollectStatistics(buffer []byte) []byte {
+	for _, c := range w.clients {
+		buffer = statistics.CollectSubscriberStatistics(buffer, w.db, w.rp, w.name, redactDestination(c.Destination()), c.Stats())
+	}
+	return statistics.CollectSubscriptionStatistics(buffer, w.db, w.rp, w.name, w.sStats)
+}
+
+func (w *BaseWriter) Stats() *statistics.SubscriptionStats {
+	return w.sStats
+}
+
+// RecentMeasurements returns the measurements recently forwarded, nil if the tracking is disabled
+func (w *BaseWriter) RecentMeasurements() []MeasurementActivity {
+	return w.recent.Snapshot()
+}
+
+func (w *BaseWriter) Name() string {
+	return w.name
+}
+
+func (w *BaseWriter) Clients() []Client {
+	return w.clients
+}
+
+func (w *BaseWriter) Start(concurrency, buffersize int) {
+	w.start(concurrency, buffersize)
+}
+
+// start starts the workers, it returns false without starting anything if the writer has been started,
+// so that a repeated Start does not leave the workers of the previous write buffer behind
+func (w *BaseWriter) start(concurrency, buffersize int) bool {
+	w.stopLock.Lock()
+	if w.started {
+		w.stopLock.Unlock()
+		w.logger.Warn("writer has been started, ignore the repeated start")
+		return false
+	}
+	w.started = true
+	w.concurrency, w.bufferSize = concurrency, buffersize
+	w.startWorkers()
+	if w.idleTimeout > 0 {
+		atomic.StoreInt64(&w.lastSend, time.Now().UnixNano())
+		w.idleDone = make(chan struct{})
+		go w.closeWhenIdle()
+	}
+	w.stopLock.Unlock()
+	if w.warmup {
+		for _, c := range w.clients {
+			go w.warmupClient(c)
+		}
+	}
+	return true
+}
+
+// startWorkers creates the write buffer and starts the workers forwarding the write requests in it
+func (w *BaseWriter) startWorkers() {
+	w.ch = make(chan *WriteRequest, w.bufferSize)
+	w.wg.Add(w.concurrency)
+	atomic.AddInt32(&w.workers, int32(w.concurrency))
+	for i := 0; i < w.concurrency; i++ {
+		go func() {
+			defer w.wg.Done()
+			w.Run()
+			// the last worker closes the clients that buffer the writes once the buffered requests are forwarded,
+			// the clients are kept if the workers are only stopped as the writer is idle
+			if atomic.AddInt32(&w.workers, -1) == 0 && w.isStopped() {
+				w.closeClients()
+			}
+		}()
+	}
+}
+
+func (w *BaseWriter) isStopped() bool {
+	w.stopLock.RLock()
+	defer w.stopLock.RUnlock()
+	return w.stopped
+}
+
+// closeWhenIdle stops the workers and closes the idle connections once no write request is sent for idleTimeout
+func (w *BaseWriter) closeWhenIdle() {
+	ticker := time.NewTicker(w.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.idleDone:
+			return
+		case <-ticker.C:
+			w.closeIfIdle()
+		}
+	}
+}
+
+func (w *BaseWriter) closeIfIdle() {
+	w.stopLock.Lock()
+	defer w.stopLock.Unlock()
+	if w.stopped || w.idle || time.Since(time.Unix(0, atomic.LoadInt64(&w.lastSend))) < w.idleTimeout {
+		return
+	}
+	w.idle = true
+	close(w.ch)
+	for _, c := range w.clients {
+		if ic, ok := c.(interface{ CloseIdleConnections() }); ok {
+			ic.CloseIdleConnections()
+		}
+	}
+	w.logger.Info("writer is idle, stop its workers", zap.Duration("idleTimeout", w.idleTimeout))
+}
+
+// resume starts the workers of the idle writer again
+func (w *BaseWriter) resume() {
+	w.stopLock.Lock()
+	defer w.stopLock.Unlock()
+	if !w.idle || w.stopped {
+		return
+	}
+	w.idle = false
+	atomic.StoreInt64(&w.lastSend, time.Now().UnixNano())
+	w.startWorkers()
+}
+
+// Workers returns the number of workers still running and the number of workers started
+func (w *BaseWriter) Workers() (running, started int) {
+	return int(atomic.LoadInt32(&w.workers)), w.concurrency
+}
+
+// closeClients closes the clients that need it, e.g. to flush the writes accumulated by them
+// flusher is a client that accumulates the writes before sending them, e.g. ObjectStoreClient
+type flusher interface {
+	Flush() error
+}
+
+// Flush sends the writes accumulated by the clients of the writer at once, it is a no-op for the clients
+// that send each write as it comes. the write requests still in the write buffer are not waited for
+func (w *BaseWriter) Flush() error {
+	var err error
+	for _, c := range w.clients {
+		f, ok := c.(flusher)
+		if !ok {
+			continue
+		}
+		if e := f.Flush(); e != nil {
+			w.logger.Error("failed to flush destination", zap.String("dest", redactDestination(c.Destination())), zap.Error(e))
+			err = e
+		}
+	}
+	return err
+}
+
+func (w *BaseWriter) closeClients() {
+	w.closeOnce.Do(func() {
+		closeDestinations(w.clients, w.logger)
+	})
+}
+
+// warmupClient pings c to set up the connection in advance, a failure is only logged
+func (w *BaseWriter) warmupClient(c Client) {
+	if err := c.Ping(); err != nil {
+		w.logger.Warn("failed to warm up the connection to destination", zap.String("dest", redactDestination(c.Destination())), zap.Error(err))
+	}
+}
+
+// Stop closes the write buffer after the write requests being sent to it are buffered,
+// it is safe to be called concurrently with Send and more than once
+func (w *BaseWriter) Stop() {
+	w.stop(nil)
+}
+
+// stop stops the writer, onStop is called under stopLock by the first stop only
+func (w *BaseWriter) stop(onStop func()) {
+	w.stopLock.Lock()
+	defer w.stopLock.Unlock()
+	if w.stopped {
+		return
+	}
+	w.stopped = true
+	if onStop != nil {
+		onStop()
+	}
+	if w.idleDone != nil {
+		close(w.idleDone)
+	}
+	if w.idle {
+		// the workers of an idle writer have already exited, or the last of them closes the clients
+		if atomic.LoadInt32(&w.workers) == 0 {
+			w.closeClients()
+		}
+		return
+	}
+	close(w.ch)
+}
+
+// Wait blocks until the workers have forwarded all the buffered write requests after Stop
+func (w *BaseWriter) Wait() {
+	w.wg.Wait()
+}
+
+type SubscriberWriter interface {
+	// Write forwards lineProtocol whose timestamps are in precision, empty for nanoseconds
+	Write(user, precision string, lineProtocol []byte)
+	WriteStatement(stmt string)
+	Name() string
+	Mode() string
+	Run()
+	Start(concurrency, buffersize int)
+	Stop()
+	Wait()
+	Clients() []Client
+	CollectStatistics(buffer []byte) []byte
+	Stats() *statistics.SubscriptionStats
+	RecentMeasurements() []MeasurementActivity
+	Workers() (running, started int)
+	Flush() error
+}
+
+type AllWriter struct {
+	BaseWriter
+}
+
+func (w *AllWriter) Write(user, precision string, lineProtocol []byte) {
+	lineProtocol, ok := w.filterLines(precision, lineProtocol)
+	if !ok {
+		return
+	}
+	if w.fanOutLimit > 0 {
+		w.Send(&WriteRequest{User: user, LineProtocol: lineProtocol, Precision: precision, FanOut: true, Track: true})
+		return
+	}
+	for i := 0; i < len(w.clients); i++ {
+		wr := &WriteRequest{Client: i, User: user, LineProtocol: lineProtocol, Precision: precision, Track: i == 0}
+		w.Send(wr)
+	}
+}
+
+func (w *AllWriter) WriteStatement(stmt string) {
+	if w.fanOutLimit > 0 {
+		w.Send(&WriteRequest{Statement: stmt, FanOut: true})
+		return
+	}
+	for i := 0; i < len(w.clients); i++ {
+		w.Send(&WriteRequest{Client: i, Statement: stmt})
+	}
+}
+
+func (w *AllWriter) Mode() string {
+	return "ALL"
+}
+
+type RoundRobinWriter struct {
+	BaseWriter
+	i uint32
+
+	// unhealthy[i] is 1 if the last health check of clients[i] failed
+	unhealthy           []int32
+	healthCheckInterval time.Duration
+	// done stops the health check, it is closed by the first Stop
+	done chan struct{}
+}
+
+// NewRoundRobinWriter creates the writer with the state of the health check allocated,
+// so that the workers and Stop never see it half set up
+func NewRoundRobinWriter(bw BaseWriter, healthCheckInterval time.Duration) *RoundRobinWriter {
+	w := &RoundRobinWriter{BaseWriter: bw, healthCheckInterval: healthCheckInterval}
+	if healthCheckInterval > 0 {
+		w.unhealthy = make([]int32, len(bw.clients))
+		w.done = make(chan struct{})
+	}
+	return w
+}
+
+// pick returns the next client in rotation, in proportion to the adaptive weights if they are enabled
+func (w *RoundRobinWriter) pick() int {
+	if w.weights != nil {
+		return w.weights.Next()
+	}
+	return int(atomic.AddUint32(&w.i, 1) % uint32(len(w.clients)))
+}
+
+// next returns the index of the client that the next write request should be sent to,
+// unhealthy clients are skipped unless all of them are unhealthy.
+// it is safe to be called concurrently
+func (w *RoundRobinWriter) next() int {
+	i := w.pick()
+	if w.unhealthy == nil {
+		return i
+	}
+	for k := 1; k < len(w.clients) && atomic.LoadInt32(&w.unhealthy[i]) == 1; k++ {
+		i = w.pick()
+	}
+	return i
+}
+
+func (w *RoundRobinWriter) Start(concurrency, buffersize int) {
+	if !w.BaseWriter.start(concurrency, buffersize) {
+		return
+	}
+	if w.done != nil {
+		go w.healthCheck()
+	}
+}
+
+func (w *RoundRobinWriter) Stop() {
+	w.BaseWriter.stop(func() {
+		if w.done != nil {
+			close(w.done)
+		}
+	})
+}
+
+func (w *RoundRobinWriter) healthCheck() {
+	ticker := time.NewTicker(w.healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.checkClients()
+		}
+	}
+}
+
+func (w *RoundRobinWriter) checkClients() {
+	for i, c := range w.clients {
+		err := c.Ping()
+		if err != nil && atomic.CompareAndSwapInt32(&w.unhealthy[i], 0, 1) {
+			w.logger.Warn("remove unhealthy destination from rotation", zap.String("dest", redactDestination(c.Destination())), zap.Error(err))
+		} else if err == nil && atomic.CompareAndSwapInt32(&w.unhealthy[i], 1, 0) {
+			w.logger.Info("destination recovered, add it back to rotation", zap.String("dest", redactDestination(c.Destination())))
+		}
+	}
+}
+
+func (w *RoundRobinWriter) Write(user, precision string, lineProtocol []byte) {
+	lineProtocol, ok := w.filterLines(precision, lineProtocol)
+	if !ok {
+		return
+	}
+	wr := &WriteRequest{Client: w.next(), User: user, LineProtocol: lineProtocol, Precision: precision, Track: true}
+	w.Send(wr)
+}
+
+func (w *RoundRobinWriter) WriteStatement(stmt string) {
+	w.Send(&WriteRequest{Client: w.next(), Statement: stmt})
+}
+
+func (w *RoundRobinWriter) Mode() string {
+	return "ANY"
+}
+
+// SingleWriter is the ANY mode writer of a subscription with only one destination,
+// every write request goes to that destination without picking a client
+type SingleWriter struct {
+	BaseWriter
+}
+
+func (w *SingleWriter) Write(user, precision string, lineProtocol []byte) {
+	lineProtocol, ok := w.filterLines(precision, lineProtocol)
+	if !ok {
+		return
+	}
+	w.Send(&WriteRequest{User: user, LineProtocol: lineProtocol, Precision: precision, Track: true})
+}
+
+func (w *SingleWriter) WriteStatement(stmt string) {
+	w.Send(&WriteRequest{Statement: stmt})
+}
+
+func (w *SingleWriter) Mode() string {
+	return "ANY"
+}
+
+type MetaClient interface {
+	Databases() map[string]*meta.DatabaseInfo
+	Database(string) (*meta.DatabaseInfo, error)
+	GetMaxSubscriptionID() uint64
+	WaitForDataChanged() chan struct{}
+}
+
+type SubscriberManager struct {
+	lock           sync.RWMutex
+	writers        map[string]map[string][]SubscriberWriter // {"db0": {"rp0": []SubscriberWriter }}
+	client         MetaClient
+	config         config.Subscriber
+	Logger         *logger.Logger
+	lastModifiedID uint64
+	overrides      *DestinationOverrides
+	// objectStore is used by the s3:// destinations, nil means an S3 compatible client built from the config
+	objectStore ObjectStore
+	closed      bool // no more writers are created after Shutdown
+	// nodeID is sent with the forwarded writes if forward-node-id is set, it is node-id or the hostname
+	nodeID string
+	// localAddr is the http bind address of this node, the destinations pointing back at it are detected
+	localAddr string
+
+	// updateLock serializes the updates of writers, running is the snapshot of the subscriptions
+	// of the running writers, which UpdateWriters diffs meta against
+	updateLock sync.Mutex
+	running    map[subscriptionKey]meta.SubscriptionInfo
+
+	// cancel stops the update goroutine started by Start, which closes updateDone when it returns
+	cancel     context.CancelFunc
+	updateDone chan struct{}
+	// exportDone is closed when the metrics export goroutine started by Start returns, nil if there is no export
+	exportDone chan struct{}
+	// resolver resolves the http+srv and https+srv destinations, srvDone is closed when the goroutine
+	// refreshing them returns, nil if they are not refreshed
+	resolver SRVResolver
+	srvDone  chan struct{}
+
+	hookLock sync.RWMutex
+	hooks    []WriteFailureHook
+	failures chan *WriteFailure
+	// the queued failures and write events are dispatched by goroutines started by Start until Stop closes dispatchDone
+	dispatchDone chan struct{}
+	dispatchWG   sync.WaitGroup
+	stopDispatch sync.Once
+
+	// observers are called with the completed writes queued in events, observed is the number of observers
+	observers []WriteObserver
+	observed  int32
+	events    chan *WriteEvent
+}
+
+// sortDestinations returns a sorted copy of destinations.
+// the clients of a writer are created in this order, so that the routing based on the
+// index of clients is the same across nodes and restarts regardless of the order of destinations in meta
+func sortDestinations(destinations []string) []string {
+	sorted := make([]string, len(destinations))
+	copy(sorted, destinations)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// newClients builds the clients of the destinations of a subscription, the destinations are neither
+// resolved nor probed. a destination resolved from SRV records takes the settings of its origin in origins.
+// the clients already built are closed if one of them fails
+func (s *SubscriberManager) newClients(sc config.SubscriptionConfig, destinations []string, origins map[string]string, wlog *logger.Logger) ([]Client, error) {
+	var proxy *url.URL
+	if sc.Proxy != "" {
+		var err error
+		proxy, err = url.Parse(sc.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("fail to parse proxy %s", err)
+		}
+	}
+	clients := make([]Client, 0, len(destinations))
+	for _, dest := range destinations {
+		dc := s.config.Destination(dest)
+		if origin, ok := origins[dest]; ok {
+			dc = s.config.Destination(origin)
+		}
+		c, err := s.newClient(sc, proxy, dest, dc, wlog)
+		if err != nil {
+			closeDestinations(clients, wlog)
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+	return clients, nil
+}
+
+func (s *SubscriberManager) newClient(sc config.SubscriptionConfig, proxy *url.URL, dest string, dc config.DestinationConfig, wlog *logger.Logger) (Client, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse %s", err)
+	}
+	var c *HTTPClient
+	switch u.Scheme {
+	case "s3":
+		store := s.objectStore
+		if store == nil {
+			store, err = newObsObjectStore(s.config.ObjectStore)
+			if err != nil {
+				return nil, fmt.Errorf("fail to create object store client: %v", err)
+			}
+		}
+		return NewObjectStoreClient(u, store, s.config.ObjectStore, wlog), nil
+	case "webhook", "webhooks":
+		tmpl := dc.WebhookTemplate
+		if tmpl == "" {
+			tmpl = DefaultWebhookTemplate
+		}
+		template, err := ParseWebhookTemplate(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook-template of destination %s: %v", dest, err)
+		}
+		wc := NewWebhookClient(u, template, time.Duration(s.config.HTTPTimeout), s.config.InsecureSkipVerify, proxy)
+		if s.config.ForwardNodeID {
+			wc.nodeIDHeader, wc.nodeID = s.config.NodeIDHeader, s.nodeID
+		}
+		wc.headers = dc.Headers
+		logHeaders(wlog, u, dc)
+		return wc, nil
+	case "http":
+		c = NewHTTPClient(u, time.Duration(s.config.HTTPTimeout), proxy)
+	case "https":
+		c, err = NewHTTPSClient(u, time.Duration(s.config.HTTPTimeout), s.config.InsecureSkipVerify, s.config.HttpsCertificate, proxy)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown subscription schema %s", u.Scheme)
+	}
+	if sc.ForwardUser {
+		c.userHeader = sc.UserHeader
+	}
+	if s.config.ForwardNodeID {
+		c.nodeIDHeader, c.nodeID = s.config.NodeIDHeader, s.nodeID
+	}
+	c.overrides = s.overrides
+	c.contentType = s.config.ContentType
+	c.gzip, c.gzipMinSize = s.config.Gzip, sc.GzipMinSize
+	if addr := dc.LocalAddr; addr != "" {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid local-addr %s of destination %s", addr, dest)
+		}
+		c.setLocalAddr(ip)
+	}
+	c.maxPoints = dc.MaxPoints
+	c.headers = dc.Headers
+	logHeaders(wlog, u, dc)
+	c.setConnMaxLifetime(time.Duration(s.config.ConnMaxLifetime))
+	c.tooLarge.cooldown = time.Duration(s.config.TooLargeCooldown)
+	if s.config.CreateOnNotFound {
+		c.createQuery = s.config.CreateQuery
+	}
+	// the maxconc query parameter of the destination overrides max-concurrency-per-destination,
+	// e.g. http://127.0.0.1:8086?maxconc=2
+	maxConcurrency := s.config.MaxConcurrencyPerDestination
+	if v := u.Query().Get("maxconc"); v != "" {
+		maxConcurrency, err = strconv.Atoi(v)
+		if err != nil || maxConcurrency < 0 {
+			return nil, fmt.Errorf("invalid maxconc %s of destination %s", v, dest)
+		}
+	}
+	c.setMaxConcurrency(maxConcurrency)
+	// the method query parameter of the destination overrides the write-method of the subscription,
+	// e.g. http://127.0.0.1:8086?method=PUT
+	c.method = sc.WriteMethod
+	if v := u.Query().Get("method"); v != "" {
+		if !config.ValidWriteMethod(v) {
+			return nil, fmt.Errorf("invalid method %s of destination %s", v, dest)
+		}
+		c.method = v
+	}
+	// the delay query parameter of the destination is for testing only, e.g. http://127.0.0.1:8086?delay=200ms
+	if v := u.Query().Get("delay"); v != "" {
+		delay, err := time.ParseDuration(v)
+		if err != nil || delay < 0 {
+			return nil, fmt.Errorf("invalid delay %s of destination %s", v, dest)
+		}
+		if s.config.AllowTestDelay {
+			c.delay = delay
+		} else {
+			wlog.Warn("delay of destination is ignored without allow-test-delay", zap.String("destination", u.Redacted()))
+		}
+	}
+	if v := u.Query().Get("precision"); v != "" {
+		if err := c.setPrecision(v); err != nil {
+			return nil, fmt.Errorf("invalid precision %s of destination %s", v, dest)
+		}
+	}
+	return c, nil
+}
+
+// checkDestinations checks that the http and https destinations neither point back at the local node
+// nor run a version older than min-destination-version
+func (s *SubscriberManager) checkDestinations(clients []Client, wlog *logger.Logger) error {
+	for _, client := range clients {
+		c, ok := client.(*HTTPClient)
+		if !ok {
+			continue
+		}
+		if s.localAddr != "" && isLocalDestination(c.url, s.localAddr) {
+			if s.config.RejectLocalDestination {
+				return fmt.Errorf("destination %s points back at the local node %s", c.url.Redacted(), s.localAddr)
+			}
+			wlog.Warn("destination points back at the local node, the writes may be forwarded in a loop",
+				zap.String("destination", c.url.Redacted()), zap.String("local", s.localAddr))
+		}
+		if s.config.MinDestinationVersion != "" {
+			if err := checkVersion(c, s.config.MinDestinationVersion, wlog); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SubscriberManager) NewSubscriberWriter(db, rp, name, mode string, destinations []string) (SubscriberWriter, error) {
+	destinations, origins, err := s.resolveDestinations(destinations)
+	if err != nil {
+		return nil, err
+	}
+	return s.newResolvedWriter(db, rp, name, mode, destinations, origins)
+}
+
+// newResolvedWriter creates the writer of the destinations returned by resolveDestinations
+func (s *SubscriberManager) newResolvedWriter(db, rp, name, mode string, destinations []string, origins map[string]string) (SubscriberWriter, error) {
+	destinations = sortDestinations(destinations)
+	sc := s.config.Subscription(db, rp, name)
+	// the logs of the writer and its clients carry the subscription, so that they can be filtered by it
+	wlog := s.Logger.Child(zap.String("db", db), zap.String("rp", rp), zap.String("sub", name), zap.String("mode", mode))
+	clients, err := s.newClients(sc, destinations, origins, wlog)
+	if err != nil {
+		return nil, err
+	}
+	// the clients may run goroutines, e.g. the periodic flush of s3, so they are closed if no writer takes them
+	w, err := s.newWriter(db, rp, name, mode, sc, clients, wlog)
+	if err != nil {
+		closeDestinations(clients, wlog)
+		return nil, err
+	}
+	return w, nil
+}
+
+func (s *SubscriberManager) newWriter(db, rp, name, mode string, sc config.SubscriptionConfig, clients []Client, wlog *logger.Logger) (SubscriberWriter, error) {
+	if err := s.checkDestinations(clients, wlog); err != nil {
+		return nil, err
+	}
+	bw := NewBaseWriter(db, rp, name, clients, wlog)
+	bw.sendTimeout = time.Duration(s.config.HTTPTimeout)
+	bw.fullTimeout = time.Duration(s.config.WriteBufferFullTimeout)
+	bw.slowEnqueue = time.Duration(s.config.SlowEnqueueThreshold)
+	bw.warmup = s.config.Warmup
+	bw.idleTimeout = time.Duration(s.config.IdleTimeout)
+	bw.filter = NewKeyFilter(sc.AllowTags, sc.DenyTags, sc.AllowFields, sc.DenyFields, sc.InjectTags)
+	bw.sampler = NewSampler(sc.SampleRate, sc.SampleMode)
+	bw.maxAge, bw.maxAgeSkew = time.Duration(sc.MaxAge), time.Duration(sc.MaxAgeSkew)
+	predicate, err := ParsePredicate(sc.Predicate)
+	if err != nil {
+		return nil, err
+	}
+	bw.predicate = predicate
+	if s.config.RecentOverflowBucket {
+		bw.recent = NewRecentMeasurementsWithOverflow(s.config.RecentMeasurements)
+	} else {
+		bw.recent = NewRecentMeasurements(s.config.RecentMeasurements)
+	}
+	bw.failures = s.failures
+	bw.observe = s.observeWrite
+	switch mode {
+	case "ALL":
+		// every write is multiplied by the number of destinations in ALL mode
+		if s.config.MaxFanOut > 0 && len(clients) > s.config.MaxFanOut {
+			if s.config.RejectAboveMaxFanOut {
+				return nil, fmt.Errorf("subscription %s.%s.%s has %d destinations, exceeds max fan-out %d",
+					db, rp, name, len(clients), s.config.MaxFanOut)
+			}
+			wlog.Warn("destinations of ALL mode subscription exceed max fan-out",
+				zap.Int("destinations", len(clients)), zap.Int("max", s.config.MaxFanOut))
+		}
+		bw.fanOutLimit = s.config.FanOutParallelism
+		return &AllWriter{BaseWriter: bw}, nil
+	case "ANY":
+		// a write that fails may have been applied, so it is only retried elsewhere if it is idempotent
+		bw.failover = s.config.AnyFailover && !sc.NonIdempotent
+		bw.retries = NewRetryBudget(s.config.RetryBudget)
+		bw.failoverBackoff = time.Duration(s.config.FailoverBackoff)
+		if len(clients) == 1 {
+			return &SingleWriter{BaseWriter: bw}, nil
+		}
+		if sc.AdaptiveWeights {
+			bw.weights = NewAdaptiveWeights(len(clients), *sc.WeightDecay, *sc.MinWeight)
+		}
+		return NewRoundRobinWriter(bw, time.Duration(s.config.HealthCheckInterval)), nil
+	}
+	return nil, fmt.Errorf("unknown subscription mode %s", mode)
+}
+
+// logHeaders logs the static headers of a destination with the sensitive values redacted
+func logHeaders(log *logger.Logger, u *url.URL, dc config.DestinationConfig) {
+	if len(dc.Headers) == 0 {
+		return
+	}
+	log.Info("static headers of destination", zap.String("destination", u.Redacted()), zap.Any("headers", dc.RedactedHeaders()))
+}
+
+// dedupSubscriptions keeps the first subscription of each name in an rp and returns the names
+// of the duplicate ones, a writer is only created for the kept subscriptions
+func dedupSubscriptions(subs []meta.SubscriptionInfo) ([]meta.SubscriptionInfo, []string) {
+	kept := make([]meta.SubscriptionInfo, 0, len(subs))
+	seen := make(map[string]struct{}, len(subs))
+	var dups []string
+	for _, sub := range subs {
+		if _, ok := seen[sub.Name]; ok {
+			dups = append(dups, sub.Name)
+			continue
+		}
+		seen[sub.Name] = struct{}{}
+		kept = append(kept, sub)
+	}
+	return kept, dups
+}
+
+// subscriptions returns the subscriptions of rpi with unique names, the duplicate ones are reported
+func (s *SubscriberManager) subscriptions(db string, rpi *meta.RetentionPolicyInfo) []meta.SubscriptionInfo {
+	subs, dups := dedupSubscriptions(rpi.Subscriptions)
+	for _, name := range dups {
+		s.Logger.Error("duplicate subscription name, only the first one is running", zap.String("db", db),
+			zap.String("rp", rpi.Name), zap.String("sub", name))
+	}
+	return subs
+}
+
+// writerSettings returns the write concurrency and buffer size of the subscription db.rp.name,
+// the subscription settings override the global ones
+func (s *SubscriberManager) writerSettings(db, rp, name string) (concurrency, bufferSize int) {
+	concurrency, bufferSize = s.config.WriteConcurrency, s.config.WriteBufferSize
+	sc := s.config.Subscription(db, rp, name)
+	if sc.WriteConcurrency > 0 {
+		concurrency = sc.WriteConcurrency
+	}
+	if sc.WriteBufferSize > 0 {
+		bufferSize = sc.WriteBufferSize
+	}
+	return concurrency, bufferSize
+}
+
+// InitWriters creates the writers of all the subscriptions in meta. like UpdateWriters, the writers are created
+// before taking s.lock, as creating them may probe the destinations, and only installed under it
+func (s *SubscriberManager) InitWriters() {
+	s.updateLock.Lock()
+	defer s.updateLock.Unlock()
+
+	writers := make(map[string]map[string][]SubscriberWriter)
+	var changes []subscriptionChange
+	s.WalkDatabases(func(dbi *meta.DatabaseInfo) {
+		writers[dbi.Name] = make(map[string][]SubscriberWriter)
+		dbi.WalkRetentionPolicy(func(rpi *meta.RetentionPolicyInfo) {
+			if rpi == nil {
+				return
+			}
+			subs := s.subscriptions(dbi.Name, rpi)
+			writers[dbi.Name][rpi.Name] = make([]SubscriberWriter, 0, len(subs))
+			for _, sub := range subs {
+				changes = append(changes, subscriptionChange{key: subscriptionKey{db: dbi.Name, rp: rpi.Name, name: sub.Name}, sub: sub})
+			}
+		})
+	})
+	s.createWriters(changes)
+	lastModifiedID := s.client.GetMaxSubscriptionID()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		for _, c := range changes {
+			if c.writer != nil {
+				c.writer.Stop()
+			}
+		}
+		return
+	}
+	s.running = make(map[subscriptionKey]meta.SubscriptionInfo)
+	for _, c := range changes {
+		if c.writer == nil {
+			continue
+		}
+		writers[c.key.db][c.key.rp] = append(writers[c.key.db][c.key.rp], c.writer)
+		s.running[c.key] = c.sub
+		s.Logger.Info("initialize subscriber writer", zap.String("db", c.key.db), zap.String("rp", c.key.rp), zap.String("sub", c.key.name),
+			zap.Strings("dest", c.sub.Destinations))
+	}
+	for db, rps := range writers {
+		s.writers[db] = rps
+	}
+	s.lastModifiedID = lastModifiedID
+}
+
+// WalkDatabases calls fn for each database, nil databases returned by a transient meta state are skipped
+func (s *SubscriberManager) WalkDatabases(fn func(db *meta.DatabaseInfo)) {
+	dbs := s.client.Databases()
+	for name, dbi := range dbs {
+		if dbi == nil {
+			s.Logger.Warn("skip nil database info", zap.String("db", name))
+			continue
+		}
+		fn(dbi)
+	}
+}
+
+// subscriptionKey identifies a subscription across meta snapshots
+type subscriptionKey struct {
+	db, rp, name string
+}
+
+// subscriptionChange is a subscription to add, recreate or remove by UpdateWriters
+type subscriptionChange struct {
+	key    subscriptionKey
+	sub    meta.SubscriptionInfo
+	writer SubscriberWriter // the new writer, nil if the subscription is removed
+	// resolved and origins are the destinations resolved by RefreshSRVDestinations,
+	// resolved is nil if the destinations are resolved when the writer is created
+	resolved []string
+	origins  map[string]string
+}
+
+// subscriptionModified reports whether the mode or destinations of a subscription
+// differ from the ones the running writer was created with
+func subscriptionModified(old, sub meta.SubscriptionInfo) bool {
+	return old.Mode != sub.Mode || !sameDestinations(old.Destinations, sub.Destinations)
+}
+
+// diffSubscriptions returns the subscriptions added, modified and removed since the running ones,
+// the added and modified ones are in the order of meta
+func (s *SubscriberManager) diffSubscriptions() []subscriptionChange {
+	var changes []subscriptionChange
+	seen := make(map[subscriptionKey]struct{}, len(s.running))
+	s.WalkDatabases(func(dbi *meta.DatabaseInfo) {
+		dbi.WalkRetentionPolicy(func(rpi *meta.RetentionPolicyInfo) {
+			if rpi == nil {
+				return
+			}
+			for _, sub := range s.subscriptions(dbi.Name, rpi) {
+				key := subscriptionKey{db: dbi.Name, rp: rpi.Name, name: sub.Name}
+				seen[key] = struct{}{}
+				if old, ok := s.running[key]; !ok || subscriptionModified(old, sub) {
+					changes = append(changes, subscriptionChange{key: key, sub: sub})
+				}
+			}
+		})
+	})
+	for key := range s.running {
+		if _, ok := seen[key]; !ok {
+			changes = append(changes, subscriptionChange{key: key})
+		}
+	}
+	return changes
+}
+
+// UpdateWriters applies the subscription changes in meta to the running writers.
+// only the subscriptions added, modified or removed since the last update are touched,
+// their writers are created before taking s.lock, so Send is only blocked while the writers are swapped
+func (s *SubscriberManager) UpdateWriters() {
+	s.updateLock.Lock()
+	defer s.updateLock.Unlock()
+
+	s.applyChanges(s.diffSubscriptions())
+}
+
+// applyChanges creates the writers of the subscriptions added or modified, swaps them with the running ones
+// and drains the replaced ones. it must be called with s.updateLock held
+func (s *SubscriberManager) applyChanges(changes []subscriptionChange) {
+	if len(changes) == 0 {
+		return
+	}
+	s.createWriters(changes)
+
+	var stopped []SubscriberWriter
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		for _, c := range changes {
+			if c.writer != nil {
+				c.writer.Stop()
+			}
+		}
+		return
+	}
+	for _, c := range changes {
+		if c.sub.Name != "" && c.writer == nil {
+			// keep the running writer if any, the subscription is retried on the next update
+			continue
+		}
+		old := s.swapWriter(c.key, c.writer)
+		if old != nil {
+			stopped = append(stopped, old)
+		}
+		if c.writer == nil {
+			delete(s.running, c.key)
+			s.Logger.Info("remove subscriber writer", zap.String("db", c.key.db), zap.String("rp", c.key.rp), zap.String("sub", c.key.name))
+			continue
+		}
+		s.running[c.key] = c.sub
+		msg := "add new subscriber writer"
+		if old != nil {
+			msg = "modify subscriber writer"
+		}
+		s.Logger.Info(msg, zap.String("db", c.key.db), zap.String("rp", c.key.rp), zap.String("sub", c.key.name),
+			zap.Strings("dest", c.sub.Destinations))
+	}
+	s.lock.Unlock()
+
+	// the replaced writers are no longer reachable by Send, wait for their workers to drain the buffered
+	// requests, so that removing or modifying a subscription does not lose the recent writes
+	s.drainWriters(stopped)
+}
+
+// createWriters creates and starts the writers of the subscriptions added or modified,
+// at most reconfigure-concurrency at once
+func (s *SubscriberManager) createWriters(changes []subscriptionChange) {
+	create := func(c *subscriptionChange) {
+		var writer SubscriberWriter
+		var err error
+		if c.resolved != nil {
+			writer, err = s.newResolvedWriter(c.key.db, c.key.rp, c.key.name, c.sub.Mode, c.resolved, c.origins)
+		} else {
+			writer, err = s.NewSubscriberWriter(c.key.db, c.key.rp, c.key.name, c.sub.Mode, c.sub.Destinations)
+		}
 		if err != nil {
-			return err
+			s.Logger.Error("fail to create subscriber", zap.String("db", c.key.db), zap.String("rp", c.key.rp), zap.String("sub", c.key.name),
+				zap.Strings("dest", c.sub.Destinations), zap.Error(err))
+			return
 		}
-		err = fmt.Errorf(string(body))
-		return err
+		writer.Start(s.writerSettings(c.key.db, c.key.rp, c.key.name))
+		c.writer = writer
 	}
-	return nil
+	limit := s.config.ReconfigureConcurrency
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, limit)
+	for i := range changes {
+		c := &changes[i]
+		if c.sub.Name == "" {
+			continue
+		}
+		if limit <= 1 {
+			create(c)
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			create(c)
+		}()
+	}
+	wg.Wait()
 }
 
-func (c *HTTPClient) Destination() string {
-	return c.url.String()
+// drainWriters stops the replaced writers and waits for them to drain for at most shutdown-timeout in total,
+// at most reconfigure-concurrency of them drain at once. the writers not drained in time, and the ones not yet
+// stopped then, keep draining in the background
+func (s *SubscriberManager) drainWriters(stopped []SubscriberWriter) {
+	if len(stopped) == 0 {
+		return
+	}
+	timeout := time.Duration(s.config.ShutdownTimeout)
+	deadline := time.Now().Add(timeout)
+	batch := s.config.ReconfigureConcurrency
+	if batch <= 0 {
+		batch = len(stopped)
+	}
+	for i := 0; i < len(stopped); i += batch {
+		end := i + batch
+		if end > len(stopped) {
+			end = len(stopped)
+		}
+		for _, w := range stopped[i:end] {
+			w.Stop()
+		}
+		if !waitDrained(stopped[i:end], time.Until(deadline)) {
+			for _, w := range stopped[end:] {
+				w.Stop()
+			}
+			s.Logger.Warn("replaced subscriber writers are not drained before timeout, they keep draining in the background",
+				zap.Int("writers", len(stopped)-i), zap.Duration("timeout", timeout))
+			return
+		}
+	}
 }
 
-func NewHTTPClient(url *url.URL, timeout time.Duration) *HTTPClient {
-	c := &http.Client{Timeout: timeout}
-	return &HTTPClient{client: c, url: url}
-}
+// waitDrained waits for the stopped writers to forward their buffered write requests,
+// it returns false if they are not done within timeout
+func waitDrained(writers []SubscriberWriter, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		for _, writer := range writers {
+			writer.Wait()
+		}
+		close(done)
+	}()
 
-func NewHTTPSClient(url *url.URL, timeout time.Duration, skipVerify bool, certs string) (*HTTPClient, error) {
-	var tlsConfig *tls.Config
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
 
-	if certs == "" {
-		tlsConfig = &tls.Config{
-			InsecureSkipVerify: true,
+// swapWriter replaces the writer of the subscription key by writer and returns the replaced one,
+// the writer is appended if there is none, and the subscription is removed if writer is nil.
+// it must be called with s.lock held
+func (s *SubscriberManager) swapWriter(key subscriptionKey, writer SubscriberWriter) SubscriberWriter {
+	rps, ok := s.writers[key.db]
+	if !ok {
+		if writer == nil {
+			return nil
 		}
-	} else {
-		cert, err := tls.X509KeyPair(
-			[]byte(crypto.DecryptFromFile(certs)),
-			[]byte(crypto.DecryptFromFile(certs)),
-		)
-		if err != nil {
-			return nil, err
+		rps = make(map[string][]SubscriberWriter)
+		s.writers[key.db] = rps
+	}
+	writers := rps[key.rp]
+	for i, w := range writers {
+		if w.Name() != key.name {
+			continue
 		}
-
-		tlsConfig = &tls.Config{
-			InsecureSkipVerify: skipVerify,
-			Certificates:       []tls.Certificate{cert},
+		if writer != nil {
+			writers[i] = writer
+		} else {
+			rps[key.rp] = append(writers[:i], writers[i+1:]...)
 		}
+		return w
 	}
-
-	transport := &http.Transport{
-		TLSClientConfig: tlsConfig,
+	if writer != nil {
+		rps[key.rp] = append(writers, writer)
 	}
-	c := &http.Client{Timeout: timeout, Transport: transport}
-	return &HTTPClient{client: c, url: url}, nil
+	return nil
 }
 
-type WriteRequest struct {
-	Client       int
-	LineProtocol []byte
+// Send forwards the line protocol with nanosecond timestamps written by user to the subscriptions of db.rp,
+// user is empty if authentication is disabled
+func (s *SubscriberManager) Send(db, rp, user string, lineProtocol []byte) {
+	s.SendPrecision(db, rp, user, "", lineProtocol)
 }
 
-type BaseWriter struct {
-	ch      chan *WriteRequest
-	clients []Client
-	db      string
-	rp      string
-	name    string
-	logger  *logger.Logger
-}
+// SendPrecision forwards the line protocol written by user with the precision query parameter of the write,
+// e.g. s, to the subscriptions of db.rp. empty precision means nanoseconds
+func (s *SubscriberManager) SendPrecision(db, rp, user, precision string, lineProtocol []byte) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 
-func NewBaseWriter(db, rp, name string, clients []Client, logger *logger.Logger) BaseWriter {
-	return BaseWriter{db: db, rp: rp, name: name, clients: clients, logger: logger}
-}
+	// no subscriber writers
+	if len(s.writers) == 0 {
+		return
+	}
 
-func (w *BaseWriter) Send(wr *WriteRequest) {
-	select {
-	case w.ch <- wr:
-	default:
-		w.logger.Error("failed to send write request to write buffer", zap.String("dest", w.clients[wr.Client].Destination()),
-			zap.String("db", w.db), zap.String("rp", w.rp))
+	if rp == "" {
+		dbi, err := s.client.Database(db)
+		if err != nil || dbi == nil {
+			s.Logger.Error("unknown database", zap.String("db", db))
+		} else {
+			rp = dbi.DefaultRetentionPolicy
+		}
 	}
-}
 
-func (w *BaseWriter) Run() {
-	for wr := range w.ch {
-		err := w.clients[wr.Client].Send(w.db, w.rp, wr.LineProtocol)
-		if err != nil {
-			w.logger.Error("failed to forward write request", zap.String("dest", w.clients[wr.Client].Destination()),
-				zap.String("db", w.db), zap.String("rp", w.rp), zap.Error(err))
+	if writer, ok := s.writers[db][rp]; ok {
+		for _, w := range writer {
+			w.Write(user, precision, lineProtocol)
 		}
 	}
 }
 
-func (w *BaseWriter) Name() string {
-	return w.name
+// SendStatement forwards stmt, which has been executed on database db, to the subscriptions of
+// all the retention policies of db, so that the destinations do not drift from the source.
+// the writers with the same mode and destinations, e.g. a subscription created on each retention policy,
+// forward it once
+func (s *SubscriberManager) SendStatement(db, stmt string) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	seen := make(map[string]struct{})
+	for _, writers := range s.writers[db] {
+		for _, w := range writers {
+			key := w.Mode() + " " + strings.Join(writerDestinations(w), " ")
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			w.WriteStatement(stmt)
+		}
+	}
 }
 
-func (w *BaseWriter) Clients() []Client {
-	return w.clients
+// writerDestinations returns the sorted destinations of the clients of w
+func writerDestinations(w SubscriberWriter) []string {
+	destinations := make([]string, 0, len(w.Clients()))
+	for _, c := range w.Clients() {
+		destinations = append(destinations, c.Destination())
+	}
+	return sortDestinations(destinations)
 }
 
-func (w *BaseWriter) Start(concurrency, buffersize int) {
-	w.ch = make(chan *WriteRequest, buffersize)
-	for i := 0; i < concurrency; i++ {
-		go w.Run()
+// CollectStatistics collects the statistics of the destinations of all the subscriber writers,
+// it is registered to the statistics pusher
+func (s *SubscriberManager) CollectStatistics(buffer []byte) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, db := range s.writers {
+		for _, rp := range db {
+			for _, writer := range rp {
+				buffer = writer.CollectStatistics(buffer)
+			}
+		}
 	}
+	return buffer, nil
 }
 
-func (w *BaseWriter) Stop() {
-	close(w.ch)
+// ResetStats zeroes the counters of all subscriptions and their destinations, e.g. for interval reporting.
+// it is safe to call with concurrent writes, an update racing with the reset is counted in either interval
+func (s *SubscriberManager) ResetStats() {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, db := range s.writers {
+		for _, rp := range db {
+			for _, writer := range rp {
+				writer.Stats().Reset()
+				for _, c := range writer.Clients() {
+					c.Stats().Reset()
+				}
+			}
+		}
+	}
 }
 
-type SubscriberWriter interface {
-	Write(lineProtocol []byte)
-	Name() string
-	Run()
-	Start(concurrency, buffersize int)
-	Stop()
-	Clients() []Client
+// DestinationResult is the result of checking the connectivity to a destination of a subscription
+type DestinationResult struct {
+	Destination string
+	Latency     time.Duration
+	Err         error
 }
 
-type AllWriter struct {
-	BaseWriter
+// TestSubscription checks the connectivity to each destination of the subscription db.rp.name
+// by pinging it with the clients built from the subscriber config, no data is forwarded
+func (s *SubscriberManager) TestSubscription(db, rp, name string) ([]DestinationResult, error) {
+	dbi, err := s.client.Database(db)
+	if err != nil {
+		return nil, err
+	}
+	if dbi == nil {
+		return nil, fmt.Errorf("database %s not exist", db)
+	}
+	rpi, err := dbi.GetRetentionPolicy(rp)
+	if err != nil {
+		return nil, err
+	}
+	var sub *meta.SubscriptionInfo
+	for i := range rpi.Subscriptions {
+		if rpi.Subscriptions[i].Name == name {
+			sub = &rpi.Subscriptions[i]
+			break
+		}
+	}
+	if sub == nil {
+		return nil, fmt.Errorf("subscription %s.%s.%s not exist", db, rpi.Name, name)
+	}
+
+	// the http+srv and https+srv destinations are resolved, so that their targets are pinged.
+	// no writer is created, so the destinations are not checked as they are when a writer is
+	destinations, origins, err := s.resolveDestinations(sub.Destinations)
+	if err != nil {
+		return nil, err
+	}
+	wlog := s.Logger.Child(zap.String("db", db), zap.String("rp", rpi.Name), zap.String("sub", name), zap.String("mode", sub.Mode))
+	clients, err := s.newClients(s.config.Subscription(db, rpi.Name, name), sortDestinations(destinations), origins, wlog)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDestinations(clients, wlog)
+	results := make([]DestinationResult, len(clients))
+	for i, c := range clients {
+		start := time.Now()
+		err := c.Ping()
+		results[i] = DestinationResult{Destination: redactDestination(c.Destination()), Latency: time.Since(start), Err: err}
+	}
+	return results, nil
 }
 
-func (w *AllWriter) Write(lineProtocol []byte) {
-	for i := 0; i < len(w.clients); i++ {
-		wr := &WriteRequest{i, lineProtocol}
-		w.Send(wr)
+// closeDestinations closes the clients holding resources, e.g. the goroutine flushing the objects of an s3 client
+func closeDestinations(clients []Client, log *logger.Logger) {
+	for _, c := range clients {
+		closer, ok := c.(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := closer.Close(); err != nil {
+			log.Error("failed to close destination", zap.String("dest", redactDestination(c.Destination())), zap.Error(err))
+		}
 	}
 }
 
-type RoundRobinWriter struct {
-	BaseWriter
-	i int32
+// SetDestinationOverride redirects the traffic of the destination dest of all the subscriptions to override
+// until ClearDestinationOverride is called
+func (s *SubscriberManager) SetDestinationOverride(dest, override string) error {
+	u, err := url.Parse(override)
+	if err != nil {
+		return fmt.Errorf("fail to parse %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unknown subscription schema %s", u.Scheme)
+	}
+	s.overrides.Set(dest, u)
+	s.Logger.Info("override subscription destination", zap.String("dest", dest), zap.String("override", override))
+	return nil
 }
 
-func (w *RoundRobinWriter) Write(lineProtocol []byte) {
-	i := atomic.AddInt32(&w.i, 1) % int32(len(w.clients))
-	wr := &WriteRequest{Client: int(i), LineProtocol: lineProtocol}
-	w.Send(wr)
+func (s *SubscriberManager) ClearDestinationOverride(dest string) {
+	s.overrides.Delete(dest)
+	s.Logger.Info("clear subscription destination override", zap.String("dest", dest))
 }
 
-type MetaClient interface {
-	Databases() map[string]*meta.DatabaseInfo
-	Database(string) (*meta.DatabaseInfo, error)
-	GetMaxSubscriptionID() uint64
-	WaitForDataChanged() chan struct{}
+// WriteFailure describes a write request that is given up on
+type WriteFailure struct {
+	Database        string
+	RetentionPolicy string
+	Subscription    string
+	Destination     string
+	Err             error
 }
 
-type SubscriberManager struct {
-	lock           sync.RWMutex
-	writers        map[string]map[string][]SubscriberWriter // {"db0": {"rp0": []SubscriberWriter }}
-	client         MetaClient
-	config         config.Subscriber
-	Logger         *logger.Logger
-	lastModifiedID uint64
+// WriteFailureHook is called with the write requests that are given up on, e.g. to trigger alerting
+type WriteFailureHook func(f *WriteFailure)
+
+// DefaultWriteFailureQueueSize is the number of failures buffered for the hooks,
+// more failures are dropped so that the writers are never blocked by slow hooks
+const DefaultWriteFailureQueueSize = 1024
+
+// RegisterWriteFailureHook registers hook to be called when a write request is finally given up on.
+// the hooks are called one by one in a separate goroutine started by Start, so they never block the writers
+func (s *SubscriberManager) RegisterWriteFailureHook(hook WriteFailureHook) {
+	s.hookLock.Lock()
+	s.hooks = append(s.hooks, hook)
+	s.hookLock.Unlock()
 }
 
-func (s *SubscriberManager) NewSubscriberWriter(db, rp, name, mode string, destinations []string) (SubscriberWriter, error) {
-	clients := make([]Client, 0, len(destinations))
-	for _, dest := range destinations {
-		u, err := url.Parse(dest)
-		if err != nil {
-			return nil, fmt.Errorf("fail to parse %s", err)
-		}
-		var c Client
-		switch u.Scheme {
-		case "http":
-			c = NewHTTPClient(u, time.Duration(s.config.HTTPTimeout))
-		case "https":
-			c, err = NewHTTPSClient(u, time.Duration(s.config.HTTPTimeout), s.config.InsecureSkipVerify, s.config.HttpsCertificate)
-			if err != nil {
-				return nil, err
+// dispatchWriteFailures calls the hooks with the queued failures until dispatchDone is closed, then with the
+// failures still queued. the channel is never closed, as the writers draining in the background may report more
+func (s *SubscriberManager) dispatchWriteFailures() {
+	defer s.dispatchWG.Done()
+	for {
+		select {
+		case f := <-s.failures:
+			s.callHooks(f)
+		case <-s.dispatchDone:
+			for {
+				select {
+				case f := <-s.failures:
+					s.callHooks(f)
+				default:
+					return
+				}
 			}
-		default:
-			return nil, fmt.Errorf("unknown subscription schema %s", u.Scheme)
 		}
-		clients = append(clients, c)
-	}
-	switch mode {
-	case "ALL":
-		return &AllWriter{BaseWriter: NewBaseWriter(db, rp, name, clients, s.Logger)}, nil
-	case "ANY":
-		return &RoundRobinWriter{BaseWriter: NewBaseWriter(db, rp, name, clients, s.Logger)}, nil
 	}
-	return nil, fmt.Errorf("unknown subscription mode %s", mode)
 }
 
-func (s *SubscriberManager) InitWriters() {
-	s.lock.Lock()
-	defer s.lock.Unlock()
+func (s *SubscriberManager) callHooks(f *WriteFailure) {
+	s.hookLock.RLock()
+	hooks := s.hooks
+	s.hookLock.RUnlock()
+	for _, hook := range hooks {
+		hook(f)
+	}
+}
 
-	s.WalkDatabases(func(dbi *meta.DatabaseInfo) {
-		s.writers[dbi.Name] = make(map[string][]SubscriberWriter)
-		dbi.WalkRetentionPolicy(func(rpi *meta.RetentionPolicyInfo) {
-			writers := make([]SubscriberWriter, 0, len(rpi.Subscriptions))
-			for _, sub := range rpi.Subscriptions {
-				writer, err := s.NewSubscriberWriter(dbi.Name, rpi.Name, sub.Name, sub.Mode, sub.Destinations)
-				if err != nil {
-					s.Logger.Error("fail to create subscriber", zap.String("db", dbi.Name), zap.String("rp", rpi.Name), zap.String("sub", sub.Name),
-						zap.Strings("dest", sub.Destinations))
-				} else {
-					writers = append(writers, writer)
-					writer.Start(s.config.WriteConcurrency, s.config.WriteBufferSize)
-					s.Logger.Info("initialize subscriber writer", zap.String("db", dbi.Name), zap.String("rp", rpi.Name), zap.String("sub", sub.Name),
-						zap.Strings("dest", sub.Destinations))
-				}
-			}
-			s.writers[dbi.Name][rpi.Name] = writers
-		})
-	})
-	s.lastModifiedID = s.client.GetMaxSubscriptionID()
+// WriteEvent describes a completed write to a destination, Err is nil if it succeeded.
+// Bytes is the size of the line protocol or statement before compression
+type WriteEvent struct {
+	Database        string
+	RetentionPolicy string
+	Subscription    string
+	Destination     string
+	Bytes           int
+	Duration        time.Duration
+	Err             error
 }
 
-func (s *SubscriberManager) WalkDatabases(fn func(db *meta.DatabaseInfo)) {
-	dbs := s.client.Databases()
-	for _, dbi := range dbs {
-		fn(dbi)
-	}
+// WriteObserver is called with each completed write, e.g. to trace the writes for debugging
+type WriteObserver interface {
+	ObserveWrite(e *WriteEvent)
 }
 
-func (s *SubscriberManager) UpdateWriters() {
-	s.lock.Lock()
-	defer s.lock.Unlock()
+// DefaultWriteEventQueueSize is the number of write events buffered for the observers,
+// more events are dropped so that the writers are never blocked by slow observers
+const DefaultWriteEventQueueSize = 1024
 
-	s.WalkDatabases(func(dbi *meta.DatabaseInfo) {
-		if _, ok := s.writers[dbi.Name]; !ok {
-			s.writers[dbi.Name] = make(map[string][]SubscriberWriter)
-		}
-		dbi.WalkRetentionPolicy(func(rpi *meta.RetentionPolicyInfo) {
-			changed := false
-			writers, ok := s.writers[dbi.Name][rpi.Name]
-			if !ok {
-				writers = make([]SubscriberWriter, 0, len(rpi.Subscriptions))
-				changed = true
-			}
-			// record origin subscription names in a set
-			originSubs := make(map[string]struct{})
-			for _, w := range writers {
-				originSubs[w.Name()] = struct{}{}
-			}
-			// add new subscriptions
-			for _, sub := range rpi.Subscriptions {
-				if _, ok := originSubs[sub.Name]; !ok {
-					writer, err := s.NewSubscriberWriter(dbi.Name, rpi.Name, sub.Name, sub.Mode, sub.Destinations)
-					if err != nil {
-						s.Logger.Error("fail to create subscriber", zap.String("db", dbi.Name), zap.String("rp", rpi.Name), zap.String("sub", sub.Name),
-							zap.Strings("dest", sub.Destinations))
-					} else {
-						writers = append(writers, writer)
-						writer.Start(s.config.WriteConcurrency, s.config.WriteBufferSize)
-						s.Logger.Info("add new subscriber writer", zap.String("db", dbi.Name), zap.String("rp", rpi.Name), zap.String("sub", sub.Name),
-							zap.Strings("dest", sub.Destinations))
-						changed = true
-					}
-				}
-				// remove all appeared subscription from the set
-				// then rest names are of the subscriptions that should be removed
-				delete(originSubs, sub.Name)
-			}
-			// if there is no subscriptions to remove,
-			// just continue
-			if len(originSubs) == 0 {
-				if changed {
-					s.writers[dbi.Name][rpi.Name] = writers
-				}
-				return
-			}
-			position := 0
-			length := len(writers)
-			for i := 0; i < length; i++ {
-				if _, ok := originSubs[writers[i].Name()]; !ok {
-					writers[position] = writers[i]
-					position++
-				} else {
-					writers[i].Stop()
-					s.Logger.Info("remove subscriber writer", zap.String("db", dbi.Name), zap.String("rp", rpi.Name), zap.String("sub", writers[i].Name()))
-				}
-			}
-			s.writers[dbi.Name][rpi.Name] = writers[0:position]
-		})
-	})
+// RegisterWriteObserver registers o to be called with each completed write, successful or not.
+// the observers are called one by one in a separate goroutine started by Start, so they never block the writers
+func (s *SubscriberManager) RegisterWriteObserver(o WriteObserver) {
+	s.hookLock.Lock()
+	s.observers = append(s.observers, o)
+	atomic.StoreInt32(&s.observed, int32(len(s.observers)))
+	s.hookLock.Unlock()
 }
 
-func (s *SubscriberManager) Send(db, rp string, lineProtocol []byte) {
-	s.lock.RLock()
-	defer s.lock.RUnlock()
-
-	// no subscriber writers
-	if len(s.writers) == 0 {
+// observeWrite queues e for the observers without blocking, the event is dropped if they fall behind
+func (s *SubscriberManager) observeWrite(e *WriteEvent) {
+	if atomic.LoadInt32(&s.observed) == 0 {
 		return
 	}
+	select {
+	case s.events <- e:
+	default:
+	}
+}
 
-	if rp == "" {
-		dbi, err := s.client.Database(db)
-		if err != nil {
-			s.Logger.Error("unknown database", zap.String("db", db))
-		} else {
-			rp = dbi.DefaultRetentionPolicy
+// dispatchWriteEvents calls the observers with the queued events until dispatchDone is closed, then with the
+// events still queued. the channel is never closed, as the writers draining in the background may queue more
+func (s *SubscriberManager) dispatchWriteEvents() {
+	defer s.dispatchWG.Done()
+	for {
+		select {
+		case e := <-s.events:
+			s.callObservers(e)
+		case <-s.dispatchDone:
+			for {
+				select {
+				case e := <-s.events:
+					s.callObservers(e)
+				default:
+					return
+				}
+			}
 		}
 	}
+}
 
-	if writer, ok := s.writers[db][rp]; ok {
-		for _, w := range writer {
-			w.Write(lineProtocol)
-		}
+func (s *SubscriberManager) callObservers(e *WriteEvent) {
+	s.hookLock.RLock()
+	observers := s.observers
+	s.hookLock.RUnlock()
+	for _, o := range observers {
+		o.ObserveWrite(e)
 	}
 }
 
@@ -378,10 +2393,110 @@ func (s *SubscriberManager) StopAllWriters() {
 	}
 }
 
+// Shutdown stops accepting new write requests and waits at most timeout for the buffered
+// write requests to be forwarded, it returns whether all of them are forwarded in time
+func (s *SubscriberManager) Shutdown(timeout time.Duration) bool {
+	s.lock.Lock()
+	s.closed = true
+	writers := make([]SubscriberWriter, 0)
+	for _, db := range s.writers {
+		for _, rp := range db {
+			for _, writer := range rp {
+				writer.Stop()
+				writers = append(writers, writer)
+			}
+		}
+	}
+	s.writers = make(map[string]map[string][]SubscriberWriter)
+	s.lock.Unlock()
+
+	if !waitDrained(writers, timeout) {
+		s.Logger.Warn("subscriber writers are not drained before shutdown timeout", zap.Duration("timeout", timeout))
+		return false
+	}
+	return true
+}
+
+// Start creates the writers of the existing subscriptions and keeps them up to date with meta
+// in a goroutine until ctx is done or Stop is called
+func (s *SubscriberManager) Start(ctx context.Context) {
+	// the failures and events are queued by the writers, so the queues are created before them
+	s.failures = make(chan *WriteFailure, DefaultWriteFailureQueueSize)
+	s.events = make(chan *WriteEvent, DefaultWriteEventQueueSize)
+	s.dispatchDone = make(chan struct{})
+	s.dispatchWG.Add(2)
+	go s.dispatchWriteFailures()
+	go s.dispatchWriteEvents()
+	s.InitWriters()
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.updateDone = make(chan struct{})
+	go func() {
+		defer close(s.updateDone)
+		s.update(ctx)
+	}()
+	if s.config.StatsdAddress != "" {
+		s.startMetricsExport(ctx)
+	}
+	if interval := time.Duration(s.config.SRVRefreshInterval); interval > 0 {
+		s.srvDone = make(chan struct{})
+		go func() {
+			defer close(s.srvDone)
+			s.refreshSRV(ctx, interval)
+		}()
+	}
+}
+
+// startMetricsExport pushes the metrics of the subscriptions to the statsd server in a goroutine until ctx is done
+func (s *SubscriberManager) startMetricsExport(ctx context.Context) {
+	e, err := NewStatsdExporter(s.config.StatsdAddress, s.config.StatsdPrefix)
+	if err != nil {
+		s.Logger.Error("failed to create statsd exporter, subscriber metrics are not exported",
+			zap.String("addr", s.config.StatsdAddress), zap.Error(err))
+		return
+	}
+	a := newMetricsAggregator()
+	s.exportDone = make(chan struct{})
+	go func() {
+		defer close(s.exportDone)
+		s.exportMetrics(ctx, a, e, time.Duration(s.config.MetricsExportInterval))
+	}()
+}
+
+// Stop stops the goroutines started by Start, then stops all the writers and
+// waits at most shutdown-timeout for them to forward the buffered write requests
+func (s *SubscriberManager) Stop() bool {
+	if s.cancel != nil {
+		s.cancel()
+		<-s.updateDone
+		if s.exportDone != nil {
+			<-s.exportDone
+		}
+		if s.srvDone != nil {
+			<-s.srvDone
+		}
+	}
+	drained := s.Shutdown(time.Duration(s.config.ShutdownTimeout))
+	if s.dispatchDone != nil {
+		s.stopDispatch.Do(func() {
+			close(s.dispatchDone)
+		})
+		s.dispatchWG.Wait()
+	}
+	return drained
+}
+
 func (s *SubscriberManager) Update() {
+	s.update(context.Background())
+}
+
+func (s *SubscriberManager) update(ctx context.Context) {
 	for {
 		ch := s.client.WaitForDataChanged()
-		<-ch
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+		}
 		maxSubscriptionID := s.client.GetMaxSubscriptionID()
 		if maxSubscriptionID > s.lastModifiedID {
 			s.UpdateWriters()
@@ -392,7 +2507,12 @@ func (s *SubscriberManager) Update() {
 
 func NewSubscriberManager(c config.Subscriber, m MetaClient, l *logger.Logger) *SubscriberManager {
 	m.Databases()
-	s := &SubscriberManager{client: m, config: c, Logger: l}
+	s := &SubscriberManager{client: m, config: c, Logger: l, overrides: NewDestinationOverrides(), resolver: net.DefaultResolver}
+	s.nodeID = c.NodeID
+	if s.nodeID == "" {
+		s.nodeID, _ = os.Hostname()
+	}
 	s.writers = make(map[string]map[string][]SubscriberWriter)
+	s.running = make(map[subscriptionKey]meta.SubscriptionInfo)
 	return s
 }
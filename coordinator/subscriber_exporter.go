@@ -0,0 +1,182 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SubscriptionMetrics are the metrics of a subscription over an export interval
+type SubscriptionMetrics struct {
+	Database        string
+	RetentionPolicy string
+	Subscription    string
+	Sent            int64 // writes accepted by a destination
+	Failed          int64 // writes a destination failed to accept
+	// Dropped is the number of write requests dropped because the write buffer is full or the writer is stopped
+	Dropped int64
+	// Latency is the mean duration of the writes, zero if there is no write in the interval
+	Latency time.Duration
+}
+
+// MetricsExporter pushes the subscriber metrics to an external metrics system, e.g. statsd
+type MetricsExporter interface {
+	Export(metrics []SubscriptionMetrics) error
+	Close() error
+}
+
+// metricsAggregator turns the cumulative statistics of the subscriptions, which are counted by the writers,
+// into the counts of each export interval
+type metricsAggregator struct {
+	// last is the statistics of each subscription at the last export
+	last map[subscriptionKey]subscriptionCounters
+}
+
+type subscriptionCounters struct {
+	sent, failed, writeNs, dropped int64
+}
+
+func newMetricsAggregator() *metricsAggregator {
+	return &metricsAggregator{last: make(map[subscriptionKey]subscriptionCounters)}
+}
+
+// since returns the increase of the cumulative counter n since prev,
+// the statistics start over if they are reset or the subscription is recreated
+func since(n, prev int64) int64 {
+	if n >= prev {
+		return n - prev
+	}
+	return n
+}
+
+// collect returns the metrics since the last collect, status is the current statistics of the subscriptions
+func (a *metricsAggregator) collect(status SubscriberStatus) []SubscriptionMetrics {
+	last := make(map[subscriptionKey]subscriptionCounters, len(status.Subscriptions))
+	metrics := make([]SubscriptionMetrics, 0, len(status.Subscriptions))
+	for _, sub := range status.Subscriptions {
+		key := subscriptionKey{db: sub.Database, rp: sub.RetentionPolicy, name: sub.Name}
+		cur := subscriptionCounters{sent: sub.Writes, failed: sub.FailedWrites, writeNs: sub.WriteNs,
+			dropped: sub.DroppedOnFull + sub.TimedOutOnFull + sub.DroppedOnStop}
+		last[key] = cur
+		prev := a.last[key]
+		m := SubscriptionMetrics{Database: key.db, RetentionPolicy: key.rp, Subscription: key.name,
+			Sent: since(cur.sent, prev.sent), Failed: since(cur.failed, prev.failed), Dropped: since(cur.dropped, prev.dropped)}
+		if writes := m.Sent + m.Failed; writes > 0 {
+			m.Latency = time.Duration(since(cur.writeNs, prev.writeNs) / writes)
+		}
+		metrics = append(metrics, m)
+	}
+	a.last = last
+	return metrics
+}
+
+// exportMetrics pushes the metrics collected by a to e every interval until ctx is done,
+// the metrics of the last interval are pushed before it returns
+func (s *SubscriberManager) exportMetrics(ctx context.Context, a *metricsAggregator, e MetricsExporter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	export := func() {
+		if err := e.Export(a.collect(s.Stats())); err != nil {
+			s.Logger.Warn("failed to export subscriber metrics", zap.Error(err))
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			export()
+			if err := e.Close(); err != nil {
+				s.Logger.Warn("failed to close subscriber metrics exporter", zap.Error(err))
+			}
+			return
+		case <-ticker.C:
+			export()
+		}
+	}
+}
+
+// statsdMaxPacketSize keeps the packets within the MTU of most networks
+const statsdMaxPacketSize = 1432
+
+// StatsdExporter pushes the metrics to a statsd server over udp, each metric is named
+// <prefix>.<database>.<retention policy>.<subscription>.<metric>
+type StatsdExporter struct {
+	conn   net.Conn
+	prefix string
+}
+
+func NewStatsdExporter(addr, prefix string) (*StatsdExporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsdExporter{conn: conn, prefix: prefix}, nil
+}
+
+// statsdValue is a metric of a subscription, typ is c for a counter and ms for a timing
+type statsdValue struct {
+	metric string
+	value  int64
+	typ    string
+}
+
+// statsdNameReplacer replaces the characters with special meaning in the statsd protocol
+var statsdNameReplacer = strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", " ", "_", "\n", "_")
+
+func (e *StatsdExporter) Export(metrics []SubscriptionMetrics) error {
+	var packet, line []byte
+	for _, m := range metrics {
+		name := e.prefix + "." + statsdNameReplacer.Replace(m.Database) + "." + statsdNameReplacer.Replace(m.RetentionPolicy) +
+			"." + statsdNameReplacer.Replace(m.Subscription) + "."
+		values := []statsdValue{{"sent", m.Sent, "c"}, {"failed", m.Failed, "c"}, {"dropped", m.Dropped, "c"}}
+		if m.Sent+m.Failed > 0 {
+			values = append(values, statsdValue{"latency", m.Latency.Milliseconds(), "ms"})
+		}
+		for _, v := range values {
+			line = append(line[:0], name...)
+			line = append(line, v.metric...)
+			line = append(line, ':')
+			line = strconv.AppendInt(line, v.value, 10)
+			line = append(line, '|')
+			line = append(line, v.typ...)
+			if len(packet) > 0 && len(packet)+1+len(line) > statsdMaxPacketSize {
+				if _, err := e.conn.Write(packet); err != nil {
+					return err
+				}
+				packet = packet[:0]
+			}
+			if len(packet) > 0 {
+				packet = append(packet, '\n')
+			}
+			packet = append(packet, line...)
+		}
+	}
+	if len(packet) == 0 {
+		return nil
+	}
+	_, err := e.conn.Write(packet)
+	return err
+}
+
+func (e *StatsdExporter) Close() error {
+	return e.conn.Close()
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/toml"
+	"github.com/openGemini/openGemini/lib/config"
+	"github.com/openGemini/openGemini/lib/errno"
+	"github.com/openGemini/openGemini/lib/logger"
+	"github.com/openGemini/openGemini/lib/statisticsPusher/statistics"
+	"github.com/openGemini/openGemini/open_src/influx/meta"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsAggregator(t *testing.T) {
+	a := newMetricsAggregator()
+	stats := statistics.NewSubscriptionStats()
+	stats.AddWrite(10*time.Millisecond, nil)
+	stats.AddWrite(30*time.Millisecond, errors.New("unavailable"))
+	status := SubscriberStatus{Subscriptions: []SubscriptionStatus{{Database: "db0", RetentionPolicy: "rp0", Name: "sub0",
+		DroppedOnFull: 2, TimedOutOnFull: 1, Writes: stats.Writes, FailedWrites: stats.FailedWrites, WriteNs: stats.WriteNs}}}
+	assert.Equal(t, []SubscriptionMetrics{{Database: "db0", RetentionPolicy: "rp0", Subscription: "sub0",
+		Sent: 1, Failed: 1, Dropped: 3, Latency: 20 * time.Millisecond}}, a.collect(status))
+
+	// the counts are the increase of the statistics since the last collect
+	status.Subscriptions[0].DroppedOnStop = 1
+	status.Subscriptions[0].Writes += 2
+	status.Subscriptions[0].WriteNs += int64(10 * time.Millisecond)
+	assert.Equal(t, []SubscriptionMetrics{{Database: "db0", RetentionPolicy: "rp0", Subscription: "sub0",
+		Sent: 2, Dropped: 1, Latency: 5 * time.Millisecond}}, a.collect(status))
+
+	// the statistics start over after a reset
+	status.Subscriptions[0] = SubscriptionStatus{Database: "db0", RetentionPolicy: "rp0", Name: "sub0", Writes: 1}
+	assert.Equal(t, []SubscriptionMetrics{{Database: "db0", RetentionPolicy: "rp0", Subscription: "sub0",
+		Sent: 1}}, a.collect(status))
+}
+
+func TestStatsdExporter(t *testing.T) {
+	receiver, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer receiver.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := &MockSubscriberMetaClient{databases: make(map[string]*meta.DatabaseInfo)}
+	client.CreateSubscription("db0", "rp0", "sub.0", "ALL", []string{server.URL})
+	conf := config.NewSubscriber()
+	conf.StatsdAddress = receiver.LocalAddr().String()
+	conf.StatsdPrefix = "test"
+	interval := 100 * time.Millisecond
+	conf.MetricsExportInterval = toml.Duration(interval)
+	s := NewSubscriberManager(conf, client, logger.NewLogger(errno.ModuleCoordinator))
+	s.Start(context.Background())
+	for i := 0; i < 3; i++ {
+		s.Send("db0", "rp0", "", []byte("cpu value=1"))
+	}
+
+	// the metrics are pushed every interval, the writes are counted in one of them
+	var sent int64
+	var arrivals []time.Time
+	buf := make([]byte, statsdMaxPacketSize)
+	for len(arrivals) < 3 {
+		assert.NoError(t, receiver.SetReadDeadline(time.Now().Add(10*interval)))
+		n, _, err := receiver.ReadFrom(buf)
+		if !assert.NoError(t, err) {
+			break
+		}
+		arrivals = append(arrivals, time.Now())
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			// the dot in the subscription name would split the metric name, it is replaced
+			assert.True(t, strings.HasPrefix(line, "test.db0.rp0.sub_0."), line)
+			if strings.HasPrefix(line, "test.db0.rp0.sub_0.sent:") {
+				v, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(line, "test.db0.rp0.sub_0.sent:"), "|c"), 10, 64)
+				assert.NoError(t, err)
+				sent += v
+			}
+		}
+	}
+	assert.Equal(t, int64(3), sent)
+	for i := 1; i < len(arrivals); i++ {
+		assert.Greater(t, arrivals[i].Sub(arrivals[i-1]), interval/2)
+	}
+	assert.True(t, s.Stop())
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+// KeyFilter removes the denied tag keys and field keys from line protocol,
+// an empty allow list allows all the keys. it also injects static tags into each point
+type KeyFilter struct {
+	allowTags   map[string]struct{}
+	denyTags    map[string]struct{}
+	allowFields map[string]struct{}
+	denyFields  map[string]struct{}
+	injectTags  models.Tags
+}
+
+func toSet(keys []string) map[string]struct{} {
+	if len(keys) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		set[k] = struct{}{}
+	}
+	return set
+}
+
+// parseTags parses the tags in key=value form, the ones without '=' are ignored
+func parseTags(tags []string) models.Tags {
+	var parsed models.Tags
+	for _, t := range tags {
+		i := strings.IndexByte(t, '=')
+		if i <= 0 {
+			continue
+		}
+		parsed = append(parsed, models.NewTag([]byte(t[:i]), []byte(t[i+1:])))
+	}
+	return parsed
+}
+
+// NewKeyFilter returns nil if there is nothing to filter or inject,
+// injectTags are in key=value form
+func NewKeyFilter(allowTags, denyTags, allowFields, denyFields, injectTags []string) *KeyFilter {
+	if len(allowTags) == 0 && len(denyTags) == 0 && len(allowFields) == 0 && len(denyFields) == 0 && len(injectTags) == 0 {
+		return nil
+	}
+	return &KeyFilter{
+		allowTags:   toSet(allowTags),
+		denyTags:    toSet(denyTags),
+		allowFields: toSet(allowFields),
+		denyFields:  toSet(denyFields),
+		injectTags:  parseTags(injectTags),
+	}
+}
+
+func keep(key string, allow, deny map[string]struct{}) bool {
+	if _, ok := deny[key]; ok {
+		return false
+	}
+	if allow == nil {
+		return true
+	}
+	_, ok := allow[key]
+	return ok
+}
+
+// FilterResult counts the keys and points removed by KeyFilter.Filter
+type FilterResult struct {
+	RemovedTags   int64
+	RemovedFields int64
+	DroppedPoints int64 // points whose fields are all removed
+}
+
+// Filter rewrites lineProtocol without the filtered keys and with the injected tags, the timestamps and
+// the encoding of the kept field values are left intact. points that fail to be parsed are dropped.
+// an injected tag does not override a tag of the same key kept in the point
+func (f *KeyFilter) Filter(lineProtocol []byte) ([]byte, FilterResult, error) {
+	var res FilterResult
+	// a zero default time keeps the points without timestamp as they are
+	points, err := models.ParsePointsWithPrecision(lineProtocol, time.Time{}, "n")
+	buf := make([]byte, 0, len(lineProtocol))
+	var fields []byte
+	for _, pt := range points {
+		tags := pt.Tags()
+		kept := tags[:0]
+		for _, t := range tags {
+			if keep(string(t.Key), f.allowTags, f.denyTags) {
+				kept = append(kept, t)
+			} else {
+				res.RemovedTags++
+			}
+		}
+		injected := false
+		for _, t := range f.injectTags {
+			if kept.Get(t.Key) == nil {
+				kept = append(kept, t)
+				injected = true
+			}
+		}
+		if injected {
+			sort.Sort(kept)
+		}
+
+		fields = fields[:0]
+		iterErr := pt.ForEachField(func(k, v []byte) bool {
+			if !keep(string(unescapeKey(k)), f.allowFields, f.denyFields) {
+				res.RemovedFields++
+				return true
+			}
+			if len(fields) > 0 {
+				fields = append(fields, ',')
+			}
+			fields = append(fields, k...)
+			fields = append(fields, '=')
+			fields = append(fields, v...)
+			return true
+		})
+		if iterErr != nil || len(fields) == 0 {
+			res.DroppedPoints++
+			continue
+		}
+
+		buf = models.AppendMakeKey(buf, pt.Name(), kept)
+		buf = append(buf, ' ')
+		buf = append(buf, fields...)
+		if !pt.Time().IsZero() {
+			buf = append(buf, ' ')
+			buf = strconv.AppendInt(buf, pt.UnixNano(), 10)
+		}
+		buf = append(buf, '\n')
+	}
+	return buf, res, err
+}
+
+// dropStale returns the lines of lineProtocol whose timestamp is not before the nanosecond timestamp minTs and
+// the number of dropped lines, the lines without timestamp are kept as they are stamped with the current time
+// by the destination. the timestamps are in units of unit nanoseconds, minTs is truncated to it
+func dropStale(lineProtocol []byte, minTs, unit int64) ([]byte, int64) {
+	minTs /= unit
+	out := make([]byte, 0, len(lineProtocol))
+	var dropped int64
+	for len(lineProtocol) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(lineProtocol, '\n'); i >= 0 {
+			line, lineProtocol = lineProtocol[:i], lineProtocol[i+1:]
+		} else {
+			line, lineProtocol = lineProtocol, nil
+		}
+		if ts, _, ok := lineTimestamp(line); ok && ts < minTs {
+			dropped++
+			continue
+		}
+		out = append(out, line...)
+		out = append(out, '\n')
+	}
+	return out, dropped
+}
+
+var keyEscaper = []struct {
+	escaped, unescaped []byte
+}{
+	{[]byte(`\,`), []byte(`,`)},
+	{[]byte(`\ `), []byte(` `)},
+	{[]byte(`\=`), []byte(`=`)},
+}
+
+func unescapeKey(k []byte) []byte {
+	if bytes.IndexByte(k, '\\') < 0 {
+		return k
+	}
+	for _, e := range keyEscaper {
+		k = bytes.ReplaceAll(k, e.escaped, e.unescaped)
+	}
+	return k
+}
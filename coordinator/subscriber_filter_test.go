@@ -0,0 +1,123 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyFilter(t *testing.T) {
+	lines := "cpu,host=server01,user=alice,region=west value=1,uid=\"u1\",load=2i 1680000000000000000\n" +
+		"mem,user=bob free=3i,uid=\"u2\"\n" +
+		"disk,host=server02 uid=\"u3\" 1680000000000000001\n"
+
+	cases := []struct {
+		name    string
+		filter  *KeyFilter
+		exp     string
+		expRes  FilterResult
+		expNone bool
+	}{
+		{
+			name:   "deny",
+			filter: NewKeyFilter(nil, []string{"user"}, nil, []string{"uid"}, nil),
+			exp: "cpu,host=server01,region=west value=1,load=2i 1680000000000000000\n" +
+				"mem free=3i\n",
+			expRes: FilterResult{RemovedTags: 2, RemovedFields: 3, DroppedPoints: 1},
+		},
+		{
+			name:   "allow",
+			filter: NewKeyFilter([]string{"host"}, nil, []string{"value", "free"}, nil, nil),
+			exp: "cpu,host=server01 value=1 1680000000000000000\n" +
+				"mem free=3i\n",
+			expRes: FilterResult{RemovedTags: 3, RemovedFields: 4, DroppedPoints: 1},
+		},
+		{
+			name:   "deny wins over allow",
+			filter: NewKeyFilter([]string{"host", "region"}, []string{"host"}, nil, []string{"uid", "load", "free"}, nil),
+			exp:    "cpu,region=west value=1 1680000000000000000\n",
+			expRes: FilterResult{RemovedTags: 4, RemovedFields: 5, DroppedPoints: 2},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out, res, err := c.filter.Filter([]byte(lines))
+			assert.NoError(t, err)
+			assert.Equal(t, c.exp, string(out))
+			assert.Equal(t, c.expRes, res)
+		})
+	}
+
+	assert.Nil(t, NewKeyFilter(nil, nil, nil, nil, nil))
+}
+
+func TestKeyFilterEscape(t *testing.T) {
+	f := NewKeyFilter(nil, []string{"a b"}, nil, []string{"x,y"}, nil)
+	out, res, err := f.Filter([]byte(`m\ 1,a\ b=1,c\=d=e\,f x\,y=1,z\ w="a b" 100`))
+	assert.NoError(t, err)
+	assert.Equal(t, "m\\ 1,c\\=d=e\\,f z\\ w=\"a b\" 100\n", string(out))
+	assert.Equal(t, FilterResult{RemovedTags: 1, RemovedFields: 1}, res)
+
+	// invalid lines are dropped and reported
+	out, _, err = f.Filter([]byte("cpu value=1\ncpu,host=a\n"))
+	assert.Error(t, err)
+	assert.Equal(t, "cpu value=1\n", string(out))
+}
+
+func TestKeyFilterInjectTags(t *testing.T) {
+	f := NewKeyFilter(nil, nil, nil, nil, []string{"source=cluster A", "dc=east", "invalid"})
+	out, res, err := f.Filter([]byte("cpu,host=server01 value=1 100"))
+	assert.NoError(t, err)
+	assert.Equal(t, "cpu,dc=east,host=server01,source=cluster\\ A value=1 100\n", string(out))
+	assert.Equal(t, FilterResult{}, res)
+
+	// the tag already in the point is kept
+	out, _, err = f.Filter([]byte("cpu,dc=west value=1 100\nmem free=3i\n\ndisk,host=a used=2i 101\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "cpu,dc=west,source=cluster\\ A value=1 100\n"+
+		"mem,dc=east,source=cluster\\ A free=3i\n"+
+		"disk,dc=east,host=a,source=cluster\\ A used=2i 101\n", string(out))
+
+	// the injected tags are added after the denied ones are removed
+	f = NewKeyFilter(nil, []string{"dc"}, nil, nil, []string{"dc=east"})
+	out, res, err = f.Filter([]byte("cpu,dc=west value=1 100"))
+	assert.NoError(t, err)
+	assert.Equal(t, "cpu,dc=east value=1 100\n", string(out))
+	assert.Equal(t, FilterResult{RemovedTags: 1}, res)
+}
+
+func TestDropStale(t *testing.T) {
+	lines := "cpu,host=server01 value=1 1000\n" +
+		"cpu,host=server02 value=2 3000\n" +
+		"mem,host=server01 free=3i\n" +
+		"disk,host=server01 path=\"/ 1000\" 2000\n" +
+		"cpu,host=server03 value=4 1999"
+	out, dropped := dropStale([]byte(lines), 2000, 1)
+	assert.Equal(t, int64(2), dropped)
+	assert.Equal(t, "cpu,host=server02 value=2 3000\n"+
+		"mem,host=server01 free=3i\n"+
+		"disk,host=server01 path=\"/ 1000\" 2000\n", string(out))
+
+	// the timestamps in seconds are compared with the nanosecond bound
+	out, dropped = dropStale([]byte(lines), 2000e9, 1e9)
+	assert.Equal(t, int64(2), dropped)
+	assert.Equal(t, "cpu,host=server02 value=2 3000\n"+
+		"mem,host=server01 free=3i\n"+
+		"disk,host=server01 path=\"/ 1000\" 2000\n", string(out))
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// localLookupTimeout bounds the lookup of the ips of a destination when checking whether it is the local node
+const localLookupTimeout = 2 * time.Second
+
+// SetLocalAddress sets the http bind address of this node, e.g. 127.0.0.1:8086 or :8086. the destinations
+// pointing back at it are warned about, or refused if reject-local-destination is set, as the writes they
+// receive are forwarded again. empty disables the check
+func (s *SubscriberManager) SetLocalAddress(addr string) {
+	s.localAddr = addr
+}
+
+// isLocalDestination reports whether u points at bindAddress of this node, it is best-effort:
+// a destination that can not be resolved, or is reached through a proxy or a load balancer, is not detected.
+// the host name of u is looked up for up to localLookupTimeout, so it must not be called with s.lock held
+func isLocalDestination(u *url.URL, bindAddress string) bool {
+	bindHost, bindPort, err := net.SplitHostPort(bindAddress)
+	if err != nil {
+		return false
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	if port != bindPort {
+		return false
+	}
+
+	host := u.Hostname()
+	bindIP := net.ParseIP(bindHost)
+	if bindHost != "" && bindIP == nil {
+		// the node binds a host name
+		return strings.EqualFold(host, bindHost)
+	}
+	if bindIP != nil && !bindIP.IsUnspecified() {
+		// the node binds a specific ip, e.g. localhost resolves to the bound 127.0.0.1
+		for _, ip := range lookupHost(host) {
+			if bindIP.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	// the node listens on all the interfaces
+	if hostname, _ := os.Hostname(); strings.EqualFold(host, hostname) || strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ips := lookupHost(host)
+	local, _ := net.InterfaceAddrs()
+	for _, ip := range ips {
+		if ip.IsLoopback() || ip.IsUnspecified() {
+			return true
+		}
+		for _, addr := range local {
+			if n, ok := addr.(*net.IPNet); ok && n.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookupHost returns the ips of host, which is either an ip or a host name, nil if it can not be resolved
+func lookupHost(host string) []net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), localLookupTimeout)
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	cancel()
+	if err != nil {
+		return nil
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips
+}
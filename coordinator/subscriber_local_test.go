@@ -0,0 +1,88 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/openGemini/openGemini/lib/config"
+	"github.com/openGemini/openGemini/lib/errno"
+	"github.com/openGemini/openGemini/lib/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsLocalDestination(t *testing.T) {
+	for _, c := range []struct {
+		dest, bind string
+		local      bool
+	}{
+		{dest: "http://127.0.0.1:8086", bind: "127.0.0.1:8086", local: true},
+		{dest: "http://127.0.0.1:8087", bind: "127.0.0.1:8086", local: false},
+		{dest: "http://localhost:8086", bind: "127.0.0.1:8086", local: true},
+		{dest: "http://localhost:8086", bind: "10.0.0.2:8086", local: false},
+		{dest: "http://localhost:8086", bind: ":8086", local: true},
+		{dest: "http://127.0.0.1:8086", bind: "0.0.0.0:8086", local: true},
+		{dest: "http://[::1]:8086/prefix?rp=raw", bind: ":8086", local: true},
+		{dest: "http://10.0.0.1:8086", bind: "10.0.0.2:8086", local: false},
+		{dest: "http://127.0.0.1", bind: ":80", local: true},
+		{dest: "https://127.0.0.1", bind: ":80", local: false},
+		{dest: "http://db.local:8086", bind: "db.local:8086", local: true},
+		{dest: "http://127.0.0.1:8086", bind: "", local: false},
+	} {
+		u, err := url.Parse(c.dest)
+		assert.NoError(t, err)
+		assert.Equal(t, c.local, isLocalDestination(u, c.bind), c.dest+" "+c.bind)
+	}
+}
+
+func TestLocalDestination(t *testing.T) {
+	lg := logger.NewLogger(errno.ModuleCoordinator)
+	var mu sync.Mutex
+	var warned []string
+	lg.GetSuppressLogger().ApplyObserver(func(log *logger.SuppressLog) {
+		mu.Lock()
+		defer mu.Unlock()
+		if log.Message != "destination points back at the local node, the writes may be forwarded in a loop" {
+			return
+		}
+		for _, f := range log.Fields {
+			if f.Key == "destination" {
+				warned = append(warned, f.String)
+			}
+		}
+	})
+	defer lg.GetSuppressLogger().ApplyObserver(nil)
+	s := NewSubscriberManager(config.NewSubscriber(), &MockSubscriberMetaClient{}, lg)
+	s.SetLocalAddress(":8086")
+
+	_, err := s.NewSubscriberWriter("db0", "rp0", "sub0", "ALL", []string{"http://127.0.0.1:8086", "http://127.0.0.1:8087"})
+	assert.NoError(t, err)
+	// spill the suppressed warning
+	lg.Info("flush logs of the writer")
+	mu.Lock()
+	assert.Equal(t, []string{"http://127.0.0.1:8086"}, warned)
+	mu.Unlock()
+
+	c := config.NewSubscriber()
+	c.RejectLocalDestination = true
+	s = NewSubscriberManager(c, &MockSubscriberMetaClient{}, lg)
+	s.SetLocalAddress(":8086")
+	_, err = s.NewSubscriberWriter("db0", "rp0", "sub0", "ALL", []string{"http://localhost:8086"})
+	assert.EqualError(t, err, "destination http://localhost:8086 points back at the local node :8086")
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// muint64 is the msgpack type of a uint64, an unsigned field is always encoded with it,
+// so that it is not decoded as a signed integer even if it is small
+const muint64 = 0xcf
+
+// AppendPointsMsgpack appends the msgpack encoding of points to b, a compact binary form of line protocol
+// for the sinks on internal links. the points are encoded as an array, each point is an array of
+// the measurement, a map of the tags, a map of the fields and the unix timestamp in nanoseconds.
+// the timestamp is omitted if the point has none, so that the destination assigns it as it does for line protocol
+func AppendPointsMsgpack(b []byte, points []models.Point) ([]byte, error) {
+	b = msgp.AppendArrayHeader(b, uint32(len(points)))
+	for _, pt := range points {
+		fields, err := pt.Fields()
+		if err != nil {
+			return nil, err
+		}
+		if pt.Time().IsZero() {
+			b = msgp.AppendArrayHeader(b, 3)
+		} else {
+			b = msgp.AppendArrayHeader(b, 4)
+		}
+		b = msgp.AppendStringFromBytes(b, pt.Name())
+		tags := pt.Tags()
+		b = msgp.AppendMapHeader(b, uint32(len(tags)))
+		for _, tag := range tags {
+			b = msgp.AppendStringFromBytes(b, tag.Key)
+			b = msgp.AppendStringFromBytes(b, tag.Value)
+		}
+		b = msgp.AppendMapHeader(b, uint32(len(fields)))
+		for key, value := range fields {
+			b = msgp.AppendString(b, key)
+			switch v := value.(type) {
+			case float64:
+				b = msgp.AppendFloat64(b, v)
+			case int64:
+				b = msgp.AppendInt64(b, v)
+			case uint64:
+				b = append(b, muint64, 0, 0, 0, 0, 0, 0, 0, 0)
+				binary.BigEndian.PutUint64(b[len(b)-8:], v)
+			case string:
+				b = msgp.AppendString(b, v)
+			case bool:
+				b = msgp.AppendBool(b, v)
+			default:
+				return nil, fmt.Errorf("unsupported type %T of field %s", value, key)
+			}
+		}
+		if !pt.Time().IsZero() {
+			b = msgp.AppendInt64(b, pt.UnixNano())
+		}
+	}
+	return b, nil
+}
+
+// EncodeLineProtocolMsgpack appends the msgpack encoding of the points of lineProtocol to b,
+// the points without a timestamp are encoded without one
+func EncodeLineProtocolMsgpack(b []byte, lineProtocol []byte) ([]byte, error) {
+	points, err := models.ParsePointsWithPrecision(lineProtocol, time.Time{}, "n")
+	if err != nil {
+		return nil, err
+	}
+	return AppendPointsMsgpack(b, points)
+}
+
+// DecodePointsMsgpack decodes the points encoded by AppendPointsMsgpack
+func DecodePointsMsgpack(b []byte) ([]models.Point, error) {
+	n, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]models.Point, 0, n)
+	for i := uint32(0); i < n; i++ {
+		var pt models.Point
+		pt, b, err = decodePointMsgpack(b)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, pt)
+	}
+	if len(b) > 0 {
+		return nil, fmt.Errorf("%d bytes left after the points", len(b))
+	}
+	return points, nil
+}
+
+func decodePointMsgpack(b []byte) (models.Point, []byte, error) {
+	sz, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		return nil, b, err
+	}
+	if sz != 3 && sz != 4 {
+		return nil, b, fmt.Errorf("point has %d elements, expect 3 or 4", sz)
+	}
+	elements := sz
+	var name string
+	if name, b, err = msgp.ReadStringBytes(b); err != nil {
+		return nil, b, err
+	}
+
+	if sz, b, err = msgp.ReadMapHeaderBytes(b); err != nil {
+		return nil, b, err
+	}
+	tags := make(models.Tags, 0, sz)
+	for i := uint32(0); i < sz; i++ {
+		var key, value []byte
+		if key, b, err = msgp.ReadStringZC(b); err != nil {
+			return nil, b, err
+		}
+		if value, b, err = msgp.ReadStringZC(b); err != nil {
+			return nil, b, err
+		}
+		tags = append(tags, models.NewTag(key, value))
+	}
+
+	if sz, b, err = msgp.ReadMapHeaderBytes(b); err != nil {
+		return nil, b, err
+	}
+	fields := make(models.Fields, sz)
+	for i := uint32(0); i < sz; i++ {
+		var key string
+		if key, b, err = msgp.ReadStringBytes(b); err != nil {
+			return nil, b, err
+		}
+		switch msgp.NextType(b) {
+		case msgp.Float64Type:
+			fields[key], b, err = msgp.ReadFloat64Bytes(b)
+		case msgp.IntType:
+			fields[key], b, err = msgp.ReadInt64Bytes(b)
+		case msgp.UintType:
+			fields[key], b, err = msgp.ReadUint64Bytes(b)
+		case msgp.StrType:
+			fields[key], b, err = msgp.ReadStringBytes(b)
+		case msgp.BoolType:
+			fields[key], b, err = msgp.ReadBoolBytes(b)
+		default:
+			return nil, b, fmt.Errorf("unsupported type %s of field %s", msgp.NextType(b), key)
+		}
+		if err != nil {
+			return nil, b, err
+		}
+	}
+
+	// the point has no timestamp if it is omitted
+	var t time.Time
+	if elements == 4 {
+		var ts int64
+		if ts, b, err = msgp.ReadInt64Bytes(b); err != nil {
+			return nil, b, err
+		}
+		t = time.Unix(0, ts)
+	}
+	pt, err := models.NewPoint(name, tags, fields, t)
+	return pt, b, err
+}
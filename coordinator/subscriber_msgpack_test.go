@@ -0,0 +1,128 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/openGemini/openGemini/lib/config"
+	"github.com/openGemini/openGemini/lib/errno"
+	"github.com/openGemini/openGemini/lib/logger"
+	"github.com/openGemini/openGemini/open_src/influx/meta"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPointsMsgpackRoundTrip(t *testing.T) {
+	lines := []byte(`cpu,host=server-01,region=west\ cn value=75.5,busy=true,state="ok \"fine\"" 1700000000000000001
+mem used=12i,free=-3i,total=9223372036854775807i 1700000000000000000
+weather\,station temp=-0.25`)
+	points, err := models.ParsePointsWithPrecision(lines, time.Unix(0, 42), "n")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	b, err := EncodeLineProtocolMsgpack(nil, lines)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// the binary form is more compact than the text
+	assert.Less(t, len(b), len(lines))
+	// the point without a timestamp is decoded without one
+	decoded, err := DecodePointsMsgpack(b)
+	if !assert.NoError(t, err) || !assert.Equal(t, 3, len(decoded)) {
+		return
+	}
+	assert.Equal(t, int64(1700000000000000001), decoded[0].UnixNano())
+	assert.True(t, decoded[2].Time().IsZero())
+	assert.Equal(t, string(lines[bytes.LastIndexByte(lines, '\n')+1:]), decoded[2].String())
+
+	// unsigned fields can not be parsed from line protocol by default
+	disk := models.MustNewPoint("disk", models.NewTags(map[string]string{"path": "/data"}),
+		models.Fields{"reads": uint64(5), "writes": uint64(18446744073709551615), "ok": false}, time.Unix(0, -1000))
+	points = append(points, disk)
+	b, err = AppendPointsMsgpack(nil, points)
+	if !assert.NoError(t, err) {
+		return
+	}
+	decoded, err = DecodePointsMsgpack(b)
+	if !assert.NoError(t, err) || !assert.Equal(t, len(points), len(decoded)) {
+		return
+	}
+	for i, pt := range points {
+		assert.Equal(t, string(pt.Name()), string(decoded[i].Name()))
+		assert.Equal(t, pt.Tags(), decoded[i].Tags())
+		fields, _ := pt.Fields()
+		decodedFields, _ := decoded[i].Fields()
+		assert.Equal(t, fields, decodedFields)
+		if pt.UnixNano() != 42 {
+			assert.Equal(t, pt.UnixNano(), decoded[i].UnixNano())
+		}
+	}
+
+	// the types of the small numbers are kept
+	fields, _ := decoded[3].Fields()
+	assert.Equal(t, uint64(5), fields["reads"])
+	fields, _ = decoded[1].Fields()
+	assert.Equal(t, int64(12), fields["used"])
+
+	_, err = DecodePointsMsgpack(b[:len(b)-1])
+	assert.Error(t, err)
+	_, err = DecodePointsMsgpack(append(b, 0))
+	assert.EqualError(t, err, "1 bytes left after the points")
+}
+
+func TestMsgpackContentType(t *testing.T) {
+	type request struct {
+		contentType string
+		points      []models.Point
+		err         error
+	}
+	ch := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		points, err := DecodePointsMsgpack(body)
+		ch <- request{contentType: r.Header.Get("Content-Type"), points: points, err: err}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	client := &MockSubscriberMetaClient{databases: make(map[string]*meta.DatabaseInfo)}
+	client.CreateSubscription("db0", "rp0", "sub0", "ALL", []string{server.URL})
+	conf := config.NewSubscriber()
+	conf.ContentType = config.MsgpackContentType
+	s := NewSubscriberManager(conf, client, logger.NewLogger(errno.ModuleCoordinator))
+	s.InitWriters()
+	defer s.StopAllWriters()
+
+	// the writes are sent in msgpack, the point without a timestamp is sent without one
+	s.Send("db0", "rp0", "", []byte("cpu,host=server-01 value=1 1700000000000000000\nmem free=3i"))
+	select {
+	case r := <-ch:
+		assert.Equal(t, config.MsgpackContentType, r.contentType)
+		if assert.NoError(t, r.err) && assert.Equal(t, 2, len(r.points)) {
+			assert.Equal(t, "cpu,host=server-01 value=1 1700000000000000000", r.points[0].String())
+			assert.Equal(t, "mem free=3i", r.points[1].String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write is not forwarded")
+	}
+}
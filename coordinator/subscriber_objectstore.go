@@ -0,0 +1,290 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/huaweicloud/huaweicloud-sdk-go-obs/obs"
+	"github.com/openGemini/openGemini/lib/config"
+	"github.com/openGemini/openGemini/lib/logger"
+	"github.com/openGemini/openGemini/lib/statisticsPusher/statistics"
+	"go.uber.org/zap"
+)
+
+// ObjectStore is the object storage the s3:// destinations upload the objects to
+type ObjectStore interface {
+	PutObject(input *obs.PutObjectInput) (*obs.PutObjectOutput, error)
+	HeadBucket(bucket string) (*obs.BaseModel, error)
+}
+
+// obsObjectStore talks to an S3 compatible object store with the signature v4
+type obsObjectStore struct {
+	client *obs.ObsClient
+}
+
+func newObsObjectStore(conf config.ObjectStoreConfig) (*obsObjectStore, error) {
+	ak, sk := conf.AccessKey, conf.SecretKey
+	if ak == "" {
+		ak = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if sk == "" {
+		sk = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	client, err := obs.New(ak, sk, conf.Endpoint, obs.WithSignature(obs.SignatureV4), obs.WithPathStyle(true))
+	if err != nil {
+		return nil, err
+	}
+	return &obsObjectStore{client: client}, nil
+}
+
+func (s *obsObjectStore) PutObject(input *obs.PutObjectInput) (*obs.PutObjectOutput, error) {
+	return s.client.PutObject(input)
+}
+
+func (s *obsObjectStore) HeadBucket(bucket string) (*obs.BaseModel, error) {
+	return s.client.HeadBucket(bucket)
+}
+
+// objectBuffer accumulates the line protocol of db.rp until it is uploaded as an object
+type objectBuffer struct {
+	db, rp string
+	start  time.Time
+	data   []byte
+}
+
+// objectStoreMaxRetainedFlushes caps the writes of a db.rp kept for the next upload after the uploads failed,
+// in multiples of the flush size, so that an unreachable object store does not grow the buffer without bound
+const objectStoreMaxRetainedFlushes = 4
+
+// ObjectStoreClient archives the line protocol to an s3:// destination, e.g. s3://bucket/prefix.
+// the writes of each db.rp are accumulated and uploaded as an object once it reaches the flush size
+// or the flush interval after its first write, and when the client is closed.
+// the writes of a failed upload are kept for the next one, they are dropped and counted in the statistics
+// if they exceed objectStoreMaxRetainedFlushes times the flush size or the client is closed
+type ObjectStoreClient struct {
+	url    *url.URL
+	store  ObjectStore
+	bucket string
+	prefix string
+	conf   config.ObjectStoreConfig
+	node   string
+	seq    uint64
+	stats  *statistics.SubscriberStats
+	logger *logger.Logger
+
+	lock    sync.Mutex
+	buffers map[string]*objectBuffer
+	done    chan struct{}
+	closed  sync.Once
+}
+
+func NewObjectStoreClient(u *url.URL, store ObjectStore, conf config.ObjectStoreConfig, logger *logger.Logger) *ObjectStoreClient {
+	node, _ := os.Hostname()
+	c := &ObjectStoreClient{
+		url:     u,
+		store:   store,
+		bucket:  u.Host,
+		prefix:  strings.Trim(u.Path, "/"),
+		conf:    conf,
+		node:    node,
+		stats:   statistics.NewSubscriberStats(),
+		logger:  logger,
+		buffers: make(map[string]*objectBuffer),
+		done:    make(chan struct{}),
+	}
+	if conf.FlushInterval > 0 {
+		go c.flushPeriodically(time.Duration(conf.FlushInterval))
+	}
+	return c
+}
+
+// Send appends lineProtocol to the object of db.rp, the object is uploaded if it reaches the flush size
+func (c *ObjectStoreClient) Send(ctx context.Context, db, rp, user string, lineProtocol []byte) error {
+	c.lock.Lock()
+	key := db + "." + rp
+	buf, ok := c.buffers[key]
+	if !ok {
+		buf = &objectBuffer{db: db, rp: rp, start: time.Now()}
+		c.buffers[key] = buf
+	}
+	buf.data = append(buf.data, lineProtocol...)
+	if len(lineProtocol) > 0 && lineProtocol[len(lineProtocol)-1] != '\n' {
+		buf.data = append(buf.data, '\n')
+	}
+	if len(buf.data) < c.conf.FlushSize {
+		c.lock.Unlock()
+		return nil
+	}
+	delete(c.buffers, key)
+	c.lock.Unlock()
+	// the writes are accepted even if the upload fails, as they are kept for the next upload,
+	// so only their loss is reported to the writer
+	if err := c.upload(buf); err != nil {
+		if e := c.requeue(buf); e != nil {
+			return e
+		}
+		c.logger.Error("failed to upload object, retry later", zap.String("dest", redactDestination(c.Destination())), zap.Error(err))
+	}
+	return nil
+}
+
+// requeue puts the writes of buf whose upload failed back before the writes of the same db.rp accumulated since,
+// an error is returned if they are dropped instead
+func (c *ObjectStoreClient) requeue(buf *objectBuffer) error {
+	select {
+	case <-c.done:
+		return c.drop(buf, "the client is closed")
+	default:
+	}
+	c.lock.Lock()
+	key := buf.db + "." + buf.rp
+	next, ok := c.buffers[key]
+	size := len(buf.data)
+	if ok {
+		size += len(next.data)
+	}
+	if size > objectStoreMaxRetainedFlushes*c.conf.FlushSize {
+		c.lock.Unlock()
+		return c.drop(buf, "too many writes are retained")
+	}
+	if ok {
+		buf.data = append(buf.data, next.data...)
+	}
+	c.buffers[key] = buf
+	c.lock.Unlock()
+	return nil
+}
+
+func (c *ObjectStoreClient) drop(buf *objectBuffer, reason string) error {
+	atomic.AddInt64(&c.stats.DroppedBytes, int64(len(buf.data)))
+	return fmt.Errorf("drop %d bytes of %s.%s failed to upload as %s", len(buf.data), buf.db, buf.rp, reason)
+}
+
+// Query is a no-op, statements do not apply to archived objects
+func (c *ObjectStoreClient) Query(ctx context.Context, db, q string) error {
+	return nil
+}
+
+// objectKey returns the key of the object of buf
+func (c *ObjectStoreClient) objectKey(buf *objectBuffer) string {
+	seq := atomic.AddUint64(&c.seq, 1)
+	key := strings.NewReplacer(
+		"{db}", buf.db,
+		"{rp}", buf.rp,
+		"{time}", buf.start.UTC().Format("20060102T150405Z"),
+		"{node}", c.node,
+		"{seq}", strconv.FormatUint(seq, 10),
+	).Replace(c.conf.KeyTemplate)
+	if c.prefix == "" {
+		return key
+	}
+	return c.prefix + "/" + key
+}
+
+func (c *ObjectStoreClient) upload(buf *objectBuffer) error {
+	input := &obs.PutObjectInput{Body: bytes.NewReader(buf.data)}
+	input.Bucket = c.bucket
+	input.Key = c.objectKey(buf)
+	input.ContentLength = int64(len(buf.data))
+	input.ContentType = config.DefaultContentType
+	if _, err := c.store.PutObject(input); err != nil {
+		return fmt.Errorf("fail to upload object %s: %v", input.Key, err)
+	}
+	c.stats.AddBytes(int64(len(buf.data)), int64(len(buf.data)))
+	return nil
+}
+
+// flush uploads the objects of all db.rp whose first write is not after before,
+// the objects failed to upload are kept for the next flush unless they are dropped
+func (c *ObjectStoreClient) flush(before time.Time) error {
+	var bufs []*objectBuffer
+	c.lock.Lock()
+	for key, buf := range c.buffers {
+		if !buf.start.After(before) {
+			bufs = append(bufs, buf)
+			delete(c.buffers, key)
+		}
+	}
+	c.lock.Unlock()
+
+	var err error
+	for _, buf := range bufs {
+		if e := c.upload(buf); e != nil {
+			err = e
+			if e = c.requeue(buf); e != nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+func (c *ObjectStoreClient) flushPeriodically(interval time.Duration) {
+	// the objects are checked a few times per interval, so that they are uploaded soon after they are due
+	tick := interval / 4
+	if tick <= 0 {
+		tick = interval
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.flush(time.Now().Add(-interval)); err != nil {
+				c.logger.Error("failed to flush objects", zap.String("dest", redactDestination(c.Destination())), zap.Error(err))
+			}
+		}
+	}
+}
+
+// Flush uploads all the accumulated writes without waiting for the flush size or interval
+func (c *ObjectStoreClient) Flush() error {
+	return c.flush(time.Now())
+}
+
+// Close uploads all the accumulated writes and stops flushing periodically, the writes failed to upload are dropped
+func (c *ObjectStoreClient) Close() error {
+	c.closed.Do(func() {
+		close(c.done)
+	})
+	return c.flush(time.Now())
+}
+
+func (c *ObjectStoreClient) Ping() error {
+	_, err := c.store.HeadBucket(c.bucket)
+	return err
+}
+
+func (c *ObjectStoreClient) Destination() string {
+	return c.url.String()
+}
+
+func (c *ObjectStoreClient) Stats() *statistics.SubscriberStats {
+	return c.stats
+}
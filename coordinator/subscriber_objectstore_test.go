@@ -0,0 +1,217 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huaweicloud/huaweicloud-sdk-go-obs/obs"
+	"github.com/influxdata/influxdb/toml"
+	"github.com/openGemini/openGemini/lib/config"
+	"github.com/openGemini/openGemini/lib/errno"
+	"github.com/openGemini/openGemini/lib/logger"
+	"github.com/openGemini/openGemini/open_src/influx/meta"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockObjectStore struct {
+	lock    sync.Mutex
+	objects map[string]string // {"bucket/key": "content"}
+	err     error
+}
+
+func (s *MockObjectStore) PutObject(input *obs.PutObjectInput) (*obs.PutObjectOutput, error) {
+	body, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.objects == nil {
+		s.objects = make(map[string]string)
+	}
+	s.objects[input.Bucket+"/"+input.Key] = string(body)
+	return &obs.PutObjectOutput{}, nil
+}
+
+func (s *MockObjectStore) HeadBucket(bucket string) (*obs.BaseModel, error) {
+	return &obs.BaseModel{}, s.err
+}
+
+func (s *MockObjectStore) Objects() map[string]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	objects := make(map[string]string, len(s.objects))
+	for k, v := range s.objects {
+		objects[k] = v
+	}
+	return objects
+}
+
+func objectKeys(objects map[string]string) []string {
+	keys := make([]string, 0, len(objects))
+	for k := range objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestObjectStoreClient(t *testing.T) {
+	u, err := url.Parse("s3://archive/cluster0")
+	assert.NoError(t, err)
+	store := &MockObjectStore{}
+	conf := config.NewObjectStoreConfig()
+	conf.KeyTemplate = "{db}/{rp}/{seq}.lp"
+	conf.FlushSize = 64
+	conf.FlushInterval = 0
+	c := NewObjectStoreClient(u, store, conf, logger.NewLogger(errno.ModuleCoordinator))
+	assert.Equal(t, "s3://archive/cluster0", c.Destination())
+	assert.NoError(t, c.Ping())
+
+	line := "cpu_load,host=server-01,region=west_cn value=75.3\n"
+	// the object is uploaded once it reaches the flush size
+	assert.NoError(t, c.Send(context.Background(), "db0", "rp0", "", []byte(line)))
+	assert.NoError(t, c.Send(context.Background(), "db1", "rp0", "", []byte("mem,host=server-01 free=3i")))
+	assert.Equal(t, 0, len(store.Objects()))
+	assert.NoError(t, c.Send(context.Background(), "db0", "rp0", "", []byte(line)))
+	assert.Equal(t, map[string]string{"archive/cluster0/db0/rp0/1.lp": line + line}, store.Objects())
+
+	// the accumulated writes are uploaded on close
+	assert.NoError(t, c.Close())
+	objects := store.Objects()
+	assert.Equal(t, []string{"archive/cluster0/db0/rp0/1.lp", "archive/cluster0/db1/rp0/2.lp"}, objectKeys(objects))
+	assert.Equal(t, "mem,host=server-01 free=3i\n", objects["archive/cluster0/db1/rp0/2.lp"])
+	assert.Equal(t, int64(2*len(line)+27), c.Stats().WriteBytes)
+
+	store.err = errors.New("access denied")
+	assert.EqualError(t, c.Ping(), "access denied")
+}
+
+func TestObjectStoreUploadFailure(t *testing.T) {
+	u, err := url.Parse("s3://archive")
+	assert.NoError(t, err)
+	store := &MockObjectStore{err: errors.New("access denied")}
+	conf := config.NewObjectStoreConfig()
+	conf.KeyTemplate = "{db}-{rp}-{seq}.lp"
+	conf.FlushSize = 16
+	conf.FlushInterval = 0
+	c := NewObjectStoreClient(u, store, conf, logger.NewLogger(errno.ModuleCoordinator))
+
+	// the writes of the failed uploads are kept for the next upload
+	assert.NoError(t, c.Send(context.Background(), "db0", "rp0", "", []byte("cpu value=1\ncpu value=2\n")))
+	assert.NoError(t, c.Send(context.Background(), "db0", "rp0", "", []byte("cpu value=3\n")))
+	assert.EqualError(t, c.Flush(), "fail to upload object db0-rp0-3.lp: access denied")
+	store.lock.Lock()
+	store.err = nil
+	store.lock.Unlock()
+	assert.NoError(t, c.Flush())
+	assert.Equal(t, map[string]string{"archive/db0-rp0-4.lp": "cpu value=1\ncpu value=2\ncpu value=3\n"}, store.Objects())
+	assert.Equal(t, int64(0), c.Stats().DroppedBytes)
+
+	// the retained writes are capped, and dropped on close
+	store.lock.Lock()
+	store.err = errors.New("access denied")
+	store.lock.Unlock()
+	line := []byte("mem,host=server-01 free=3i\n")
+	assert.NoError(t, c.Send(context.Background(), "db0", "rp0", "", line))
+	assert.NoError(t, c.Send(context.Background(), "db0", "rp0", "", line))
+	assert.EqualError(t, c.Send(context.Background(), "db0", "rp0", "", line), "drop 81 bytes of db0.rp0 failed to upload as too many writes are retained")
+	assert.Equal(t, int64(3*len(line)), c.Stats().DroppedBytes)
+	assert.NoError(t, c.Send(context.Background(), "db0", "rp0", "", line))
+	assert.EqualError(t, c.Close(), "drop 27 bytes of db0.rp0 failed to upload as the client is closed")
+	assert.Equal(t, int64(4*len(line)), c.Stats().DroppedBytes)
+	assert.Equal(t, 1, len(store.Objects()))
+}
+
+func TestObjectStoreFlushInterval(t *testing.T) {
+	u, err := url.Parse("s3://archive")
+	assert.NoError(t, err)
+	store := &MockObjectStore{}
+	conf := config.NewObjectStoreConfig()
+	conf.KeyTemplate = "{db}-{rp}-{seq}.lp"
+	conf.FlushInterval = toml.Duration(40 * time.Millisecond)
+	c := NewObjectStoreClient(u, store, conf, logger.NewLogger(errno.ModuleCoordinator))
+	defer c.Close()
+
+	assert.NoError(t, c.Send(context.Background(), "db0", "rp0", "", []byte("cpu value=1\n")))
+	assert.Eventually(t, func() bool {
+		return store.Objects()["archive/db0-rp0-1.lp"] == "cpu value=1\n"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestObjectStoreDestination(t *testing.T) {
+	client := &MockSubscriberMetaClient{databases: make(map[string]*meta.DatabaseInfo)}
+	client.CreateSubscription("db0", "rp0", "sub0", "ALL", []string{"s3://archive"})
+	conf := config.NewSubscriber()
+	conf.ObjectStore.KeyTemplate = "{db}/{rp}/{node}-{seq}.lp"
+	s := NewSubscriberManager(conf, client, logger.NewLogger(errno.ModuleCoordinator))
+	store := &MockObjectStore{}
+	s.objectStore = store
+	s.InitWriters()
+
+	s.Send("db0", "rp0", "", []byte("cpu value=1\n"))
+	s.Send("db0", "rp0", "", []byte("cpu value=2\n"))
+	// the accumulated writes are flushed when the writer stops
+	assert.True(t, s.Shutdown(5*time.Second))
+	node, _ := os.Hostname()
+	assert.Equal(t, map[string]string{"archive/db0/rp0/" + node + "-1.lp": "cpu value=1\ncpu value=2\n"}, store.Objects())
+}
+
+func TestObjectStoreFlush(t *testing.T) {
+	u, err := url.Parse("s3://archive")
+	assert.NoError(t, err)
+	store := &MockObjectStore{}
+	conf := config.NewObjectStoreConfig()
+	conf.KeyTemplate = "{db}-{rp}-{seq}.lp"
+	c := NewObjectStoreClient(u, store, conf, logger.NewLogger(errno.ModuleCoordinator))
+	defer c.Close()
+	clients := []Client{c, &MockSubscriberClient{"http://127.0.0.1:8086"}}
+	w := &AllWriter{NewBaseWriter("db0", "rp0", "sub0", clients, logger.NewLogger(errno.ModuleCoordinator))}
+	w.Start(1, 10)
+	defer w.Stop()
+
+	w.Write("", "", []byte("cpu value=1\n"))
+	assert.Eventually(t, func() bool {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return len(c.buffers) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	// the partial object is uploaded long before the flush interval
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, map[string]string{"archive/db0-rp0-1.lp": "cpu value=1\n"}, store.Objects())
+
+	store.err = errors.New("access denied")
+	w.Write("", "", []byte("cpu value=2\n"))
+	assert.Eventually(t, func() bool {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return len(c.buffers) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.EqualError(t, w.Flush(), "fail to upload object db0-rp0-2.lp: access denied")
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+// predicateOps are the comparison operators of Predicate, the two-character ones are matched first
+// at the first operator character
+var predicateOps = []string{"==", "!=", ">=", "<=", ">", "<"}
+
+// Predicate matches the points whose field or tag key compares to a value, e.g. status == "error" or value > 10.
+// a quoted value is compared as a string, true and false as a boolean, and a number numerically.
+// a field takes precedence over a tag of the same key, a point without the key never matches
+type Predicate struct {
+	key   string
+	op    string
+	str   string
+	num   float64
+	isNum bool
+	b     bool
+	isB   bool
+}
+
+// ParsePredicate parses a predicate in "key op value" form, it returns nil if s is empty
+func ParsePredicate(s string) (*Predicate, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	i := strings.IndexAny(s, "=!<>")
+	for _, op := range predicateOps {
+		if i < 0 || !strings.HasPrefix(s[i:], op) {
+			continue
+		}
+		p := &Predicate{key: strings.TrimSpace(s[:i]), op: op}
+		value := strings.TrimSpace(s[i+len(op):])
+		if p.key == "" || value == "" {
+			break
+		}
+		switch {
+		case strings.HasPrefix(value, `"`):
+			str, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid predicate %s: %v", s, err)
+			}
+			p.str = str
+		case value == "true" || value == "false":
+			if op != "==" && op != "!=" {
+				return nil, fmt.Errorf("invalid predicate %s: booleans only support == and !=", s)
+			}
+			p.b, p.isB = value == "true", true
+		default:
+			num, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				// a bare word is compared as a string
+				p.str = value
+			} else {
+				p.num, p.isNum = num, true
+			}
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("invalid predicate %s: it must be in key op value form", s)
+}
+
+// compare reports whether the result of a comparison, -1, 0 or 1, satisfies the operator
+func (p *Predicate) compare(c int) bool {
+	switch p.op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+	return false
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Match reports whether pt satisfies the predicate
+func (p *Predicate) Match(pt models.Point) bool {
+	fields, err := pt.Fields()
+	if err != nil {
+		return false
+	}
+	if v, ok := fields[p.key]; ok {
+		switch v := v.(type) {
+		case float64:
+			return p.isNum && p.compare(compareFloat(v, p.num))
+		case int64:
+			return p.isNum && p.compare(compareFloat(float64(v), p.num))
+		case uint64:
+			return p.isNum && p.compare(compareFloat(float64(v), p.num))
+		case string:
+			return !p.isNum && !p.isB && p.compare(strings.Compare(v, p.str))
+		case bool:
+			if !p.isB {
+				return false
+			}
+			if p.op == "==" {
+				return v == p.b
+			}
+			return v != p.b
+		}
+		return false
+	}
+	tag := pt.Tags().Get([]byte(p.key))
+	if tag == nil {
+		return false
+	}
+	if p.isNum {
+		num, err := strconv.ParseFloat(string(tag), 64)
+		return err == nil && p.compare(compareFloat(num, p.num))
+	}
+	return !p.isB && p.compare(strings.Compare(string(tag), p.str))
+}
+
+// Filter returns the lines of lineProtocol that match the predicate as they are, and the number of dropped points.
+// the lines that fail to be parsed are dropped
+func (p *Predicate) Filter(lineProtocol []byte) ([]byte, int64) {
+	out := make([]byte, 0, len(lineProtocol))
+	var dropped int64
+	for len(lineProtocol) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(lineProtocol, '\n'); i >= 0 {
+			line, lineProtocol = lineProtocol[:i], lineProtocol[i+1:]
+		} else {
+			line, lineProtocol = lineProtocol, nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		points, err := models.ParsePointsWithPrecision(line, time.Time{}, "n")
+		if err != nil || len(points) != 1 || !p.Match(points[0]) {
+			dropped++
+			continue
+		}
+		out = append(out, line...)
+		out = append(out, '\n')
+	}
+	return out, dropped
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePredicate(t *testing.T) {
+	p, err := ParsePredicate("")
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+
+	p, err = ParsePredicate(`msg == "a>=b"`)
+	assert.NoError(t, err)
+	assert.Equal(t, &Predicate{key: "msg", op: "==", str: "a>=b"}, p)
+
+	p, err = ParsePredicate("value>=10")
+	assert.NoError(t, err)
+	assert.Equal(t, &Predicate{key: "value", op: ">=", num: 10, isNum: true}, p)
+
+	for _, s := range []string{"value", "value = 1", "== 1", "value >", `msg == "a`, "ok > true"} {
+		_, err = ParsePredicate(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestPredicateFilter(t *testing.T) {
+	lines := []string{
+		"cpu,host=server01,zone=1 status=\"error\",value=12.5,count=3i,ok=false\n",
+		"cpu,host=server02,zone=2 status=\"ok\",value=8,count=30i,ok=true\n",
+		"mem,host=server03 free=3i\n",
+	}
+	cases := []struct {
+		predicate string
+		kept      []int
+	}{
+		{predicate: `status == "error"`, kept: []int{0}},
+		{predicate: `status != "error"`, kept: []int{1}},
+		{predicate: "value > 10", kept: []int{0}},
+		{predicate: "value <= 8", kept: []int{1}},
+		{predicate: "count >= 3", kept: []int{0, 1}},
+		{predicate: "count < 3", kept: nil},
+		{predicate: "ok == true", kept: []int{1}},
+		{predicate: "ok != true", kept: []int{0}},
+		// a string is never compared to a number
+		{predicate: "status == 1", kept: nil},
+		// tags are compared as strings or numbers
+		{predicate: "host == server03", kept: []int{2}},
+		{predicate: "zone > 1", kept: []int{1}},
+		{predicate: "free == 3", kept: []int{2}},
+	}
+	lineProtocol := []byte(strings.Join(lines, ""))
+	for _, c := range cases {
+		p, err := ParsePredicate(c.predicate)
+		assert.NoError(t, err, c.predicate)
+		out, dropped := p.Filter(lineProtocol)
+		var exp string
+		for _, i := range c.kept {
+			exp += lines[i]
+		}
+		assert.Equal(t, exp, string(out), c.predicate)
+		assert.Equal(t, int64(len(lines)-len(c.kept)), dropped, c.predicate)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MeasurementActivity is the number of points of a measurement recently forwarded by a subscription
+type MeasurementActivity struct {
+	Measurement string `json:"measurement"`
+	Points      int64  `json:"points"`
+	LastSeenNs  int64  `json:"lastSeenNs"`
+	// Overflow marks the bucket of the measurements beyond the tracked ones
+	Overflow bool `json:"overflow,omitempty"`
+}
+
+// overflowMeasurement is the name of the bucket of the measurements beyond the tracked ones,
+// it is empty as no measurement is named so
+const overflowMeasurement = ""
+
+// RecentMeasurements tracks the measurements forwarded by a subscription, at most size of them are kept,
+// the least recently seen one is evicted to make room for a new one, or the new one is counted in the
+// overflow bucket if it is enabled
+type RecentMeasurements struct {
+	lock         sync.Mutex
+	size         int
+	measurements map[string]*MeasurementActivity
+	// other counts the measurements beyond size, nil if the least recently seen one is evicted instead
+	other *MeasurementActivity
+}
+
+// NewRecentMeasurementsWithOverflow is like NewRecentMeasurements, but counts the points of the
+// measurements beyond size in a single overflow bucket instead of evicting the tracked ones
+func NewRecentMeasurementsWithOverflow(size int) *RecentMeasurements {
+	r := NewRecentMeasurements(size)
+	if r != nil {
+		r.other = &MeasurementActivity{Measurement: overflowMeasurement, Overflow: true}
+	}
+	return r
+}
+
+// NewRecentMeasurements returns nil if the tracking is disabled, i.e. size is not positive
+func NewRecentMeasurements(size int) *RecentMeasurements {
+	if size <= 0 {
+		return nil
+	}
+	return &RecentMeasurements{size: size, measurements: make(map[string]*MeasurementActivity, size)}
+}
+
+// measurementName returns the measurement of a point, which ends at the first unescaped comma or space
+func measurementName(line []byte) []byte {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case ',', ' ':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Observe counts the points of lineProtocol by measurement
+func (r *RecentMeasurements) Observe(lineProtocol []byte) {
+	counts := make(map[string]int64)
+	for len(lineProtocol) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(lineProtocol, '\n'); i >= 0 {
+			line, lineProtocol = lineProtocol[:i], lineProtocol[i+1:]
+		} else {
+			line, lineProtocol = lineProtocol, nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		counts[string(measurementName(line))]++
+	}
+
+	now := time.Now().UnixNano()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for name, n := range counts {
+		m, ok := r.measurements[name]
+		switch {
+		case ok:
+		case len(r.measurements) < r.size:
+			m = &MeasurementActivity{Measurement: name}
+			r.measurements[name] = m
+		case r.other != nil:
+			m = r.other
+		default:
+			r.evict()
+			m = &MeasurementActivity{Measurement: name}
+			r.measurements[name] = m
+		}
+		m.Points += n
+		m.LastSeenNs = now
+	}
+}
+
+// evict removes the least recently seen measurement, it must be called with r.lock held
+func (r *RecentMeasurements) evict() {
+	var oldest *MeasurementActivity
+	for _, m := range r.measurements {
+		if oldest == nil || m.LastSeenNs < oldest.LastSeenNs {
+			oldest = m
+		}
+	}
+	if oldest != nil {
+		delete(r.measurements, oldest.Measurement)
+	}
+}
+
+// Snapshot returns the tracked measurements sorted by the number of points in descending order,
+// followed by the overflow bucket if any point is counted in it
+func (r *RecentMeasurements) Snapshot() []MeasurementActivity {
+	if r == nil {
+		return nil
+	}
+	r.lock.Lock()
+	activities := make([]MeasurementActivity, 0, len(r.measurements))
+	for _, m := range r.measurements {
+		activities = append(activities, *m)
+	}
+	var other *MeasurementActivity
+	if r.other != nil && r.other.Points > 0 {
+		o := *r.other
+		other = &o
+	}
+	r.lock.Unlock()
+	sort.Slice(activities, func(i, j int) bool {
+		if activities[i].Points != activities[j].Points {
+			return activities[i].Points > activities[j].Points
+		}
+		return activities[i].Measurement < activities[j].Measurement
+	})
+	// the overflow bucket comes last whatever its number of points
+	if other != nil {
+		activities = append(activities, *other)
+	}
+	return activities
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecentMeasurements(t *testing.T) {
+	assert.Nil(t, NewRecentMeasurements(0))
+	var disabled *RecentMeasurements
+	assert.Nil(t, disabled.Snapshot())
+
+	r := NewRecentMeasurements(2)
+	r.Observe([]byte("mem free=3i\n"))
+	r.Observe([]byte("cpu,host=server01 value=1\ncpu,host=server02 value=2\n\n# comment\n"))
+	r.Observe([]byte("my\\ cpu,host=server01 value=1\ncpu value=3"))
+	activities := r.Snapshot()
+	assert.Equal(t, 2, len(activities))
+	assert.Equal(t, "cpu", activities[0].Measurement)
+	assert.Equal(t, int64(3), activities[0].Points)
+	// mem is the least recently seen one, it is evicted for the new measurement
+	assert.Equal(t, "my\\ cpu", activities[1].Measurement)
+	assert.Equal(t, int64(1), activities[1].Points)
+}
+
+func TestRecentMeasurementsOverflow(t *testing.T) {
+	assert.Nil(t, NewRecentMeasurementsWithOverflow(0))
+
+	r := NewRecentMeasurementsWithOverflow(2)
+	r.Observe([]byte("mem free=3i\ncpu value=1\n"))
+	// no point is counted in the overflow bucket yet
+	assert.Equal(t, 2, len(r.Snapshot()))
+
+	r.Observe([]byte("disk used=1i\nnet rx=1i\nnet rx=2i\ncpu value=2\nother value=1\n"))
+	activities := r.Snapshot()
+	assert.Equal(t, 3, len(activities))
+	// the tracked measurements are kept instead of being evicted
+	assert.Equal(t, "cpu", activities[0].Measurement)
+	assert.Equal(t, int64(2), activities[0].Points)
+	assert.Equal(t, "mem", activities[1].Measurement)
+	assert.False(t, activities[1].Overflow)
+	// the bucket has no measurement name, so it never collides with a measurement named other
+	assert.Equal(t, MeasurementActivity{Points: 4, LastSeenNs: activities[2].LastSeenNs, Overflow: true}, activities[2])
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"sync"
+)
+
+// retryBudgetMaxTokens is the number of retries a budget holds at most, a budget starts full
+const retryBudgetMaxTokens = 10
+
+// RetryBudget caps the retries to a fraction of the successful writes, so that the retries
+// stop instead of amplifying the load during a broad outage. each successful write deposits
+// ratio of a retry, each retry withdraws a whole one
+type RetryBudget struct {
+	lock   sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+// NewRetryBudget returns nil if the retries are not limited, i.e. ratio is not positive
+func NewRetryBudget(ratio float64) *RetryBudget {
+	if ratio <= 0 {
+		return nil
+	}
+	return &RetryBudget{ratio: ratio, tokens: retryBudgetMaxTokens}
+}
+
+// Deposit records a successful write
+func (b *RetryBudget) Deposit() {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	b.tokens += b.ratio
+	if b.tokens > retryBudgetMaxTokens {
+		b.tokens = retryBudgetMaxTokens
+	}
+	b.lock.Unlock()
+}
+
+// Withdraw reports whether a retry is allowed and takes it from the budget if so
+func (b *RetryBudget) Withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
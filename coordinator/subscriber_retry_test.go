@@ -0,0 +1,51 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryBudget(t *testing.T) {
+	assert.Nil(t, NewRetryBudget(0))
+	var unlimited *RetryBudget
+	assert.True(t, unlimited.Withdraw())
+	unlimited.Deposit()
+
+	b := NewRetryBudget(0.5)
+	for i := 0; i < retryBudgetMaxTokens; i++ {
+		assert.True(t, b.Withdraw())
+	}
+	// the budget is exhausted, the retries are suppressed until the successes recover it
+	assert.False(t, b.Withdraw())
+	b.Deposit()
+	assert.False(t, b.Withdraw())
+	b.Deposit()
+	assert.True(t, b.Withdraw())
+	assert.False(t, b.Withdraw())
+
+	// the budget never exceeds the max tokens
+	for i := 0; i < 100; i++ {
+		b.Deposit()
+	}
+	for i := 0; i < retryBudgetMaxTokens; i++ {
+		assert.True(t, b.Withdraw())
+	}
+	assert.False(t, b.Withdraw())
+}
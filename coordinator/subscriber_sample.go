@@ -0,0 +1,88 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	SampleModeSeries = "series"
+	SampleModeRandom = "random"
+)
+
+// Sampler keeps a fraction of the points of line protocol. in series mode the points are sampled
+// by the hash of their series key, so that a series is either forwarded as a whole or not at all
+type Sampler struct {
+	threshold uint64
+	random    bool
+}
+
+// NewSampler returns nil if all the points are kept, i.e. rate is not in (0, 1)
+func NewSampler(rate float64, mode string) *Sampler {
+	if rate <= 0 || rate >= 1 {
+		return nil
+	}
+	return &Sampler{
+		threshold: uint64(rate * math.MaxUint64),
+		random:    mode == SampleModeRandom,
+	}
+}
+
+// seriesKey returns the measurement and tags of a point, which end at the first unescaped space
+func seriesKey(line []byte) []byte {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case ' ':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func (s *Sampler) sampled(line []byte) bool {
+	if s.random {
+		return rand.Uint64() < s.threshold
+	}
+	return xxhash.Sum64(seriesKey(line)) < s.threshold
+}
+
+// Sample returns the sampled lines of lineProtocol, empty lines are dropped
+func (s *Sampler) Sample(lineProtocol []byte) []byte {
+	out := make([]byte, 0, len(lineProtocol))
+	for len(lineProtocol) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(lineProtocol, '\n'); i >= 0 {
+			line, lineProtocol = lineProtocol[:i], lineProtocol[i+1:]
+		} else {
+			line, lineProtocol = lineProtocol, nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || !s.sampled(line) {
+			continue
+		}
+		out = append(out, line...)
+		out = append(out, '\n')
+	}
+	return out
+}
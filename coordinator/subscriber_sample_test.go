@@ -0,0 +1,56 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampler(t *testing.T) {
+	assert.Nil(t, NewSampler(0, SampleModeSeries))
+	assert.Nil(t, NewSampler(1, SampleModeRandom))
+
+	const series, points = 2000, 5
+	var buf bytes.Buffer
+	for p := 0; p < points; p++ {
+		for s := 0; s < series; s++ {
+			fmt.Fprintf(&buf, "cpu,host=server\\ %d,region=west value=%d %d\n", s, p, p)
+		}
+	}
+	lines := buf.Bytes()
+
+	for _, mode := range []string{SampleModeSeries, SampleModeRandom} {
+		out := NewSampler(0.3, mode).Sample(lines)
+		rate := float64(bytes.Count(out, []byte{'\n'})) / float64(series*points)
+		assert.InDelta(t, 0.3, rate, 0.05, mode)
+	}
+
+	// a series is either forwarded as a whole or not at all
+	out := NewSampler(0.3, SampleModeSeries).Sample(lines)
+	counts := make(map[string]int)
+	for _, line := range bytes.Split(bytes.TrimSpace(out), []byte{'\n'}) {
+		counts[string(seriesKey(line))]++
+	}
+	for key, n := range counts {
+		assert.Equal(t, points, n, key)
+	}
+	assert.Equal(t, []byte("cpu,host=server\\ 1,region=west"), seriesKey([]byte("cpu,host=server\\ 1,region=west value=1 1")))
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SRVResolver looks up the SRV records of a name, it is implemented by *net.Resolver
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// srvLookupTimeout bounds the lookup of the SRV records of a destination
+const srvLookupTimeout = 10 * time.Second
+
+// srvScheme returns the scheme of the destinations resolved from an http+srv or https+srv url,
+// ok is false if u is not resolved by SRV records
+func srvScheme(u *url.URL) (scheme string, ok bool) {
+	switch u.Scheme {
+	case "http+srv":
+		return "http", true
+	case "https+srv":
+		return "https", true
+	}
+	return "", false
+}
+
+// hasSRVDestination reports whether any of destinations is resolved by SRV records
+func hasSRVDestination(destinations []string) bool {
+	for _, dest := range destinations {
+		if strings.Contains(dest, "+srv://") {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveDestinations replaces each http+srv or https+srv destination by one destination per target of the SRV
+// records of its host, keeping the path and query, e.g. http+srv://_influxdb._tcp.example.com resolves to
+// http://node1.example.com:8086 and http://node2.example.com:8086. the other destinations are kept as they are.
+// origins maps each resolved destination to the SRV destination it is resolved from, whose settings it takes.
+// each lookup may take up to srvLookupTimeout, so it must not be called with s.lock or s.updateLock held
+func (s *SubscriberManager) resolveDestinations(destinations []string) ([]string, map[string]string, error) {
+	if !hasSRVDestination(destinations) {
+		return destinations, nil, nil
+	}
+	resolved := make([]string, 0, len(destinations))
+	origins := make(map[string]string)
+	for _, dest := range destinations {
+		u, err := url.Parse(dest)
+		if err != nil {
+			return nil, nil, fmt.Errorf("fail to parse %s", err)
+		}
+		scheme, ok := srvScheme(u)
+		if !ok {
+			resolved = append(resolved, dest)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), srvLookupTimeout)
+		_, records, err := s.resolver.LookupSRV(ctx, "", "", u.Hostname())
+		cancel()
+		if err != nil {
+			return nil, nil, fmt.Errorf("fail to look up SRV records of %s: %v", dest, err)
+		}
+		if len(records) == 0 {
+			return nil, nil, fmt.Errorf("no SRV record of %s", dest)
+		}
+		for _, r := range records {
+			target := *u
+			target.Scheme = scheme
+			target.Host = net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port)))
+			resolved = append(resolved, target.String())
+			origins[target.String()] = dest
+		}
+	}
+	return resolved, origins, nil
+}
+
+// refreshSRV recreates the writers whose SRV destinations resolve to other targets every interval until ctx is done
+func (s *SubscriberManager) refreshSRV(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.RefreshSRVDestinations()
+		}
+	}
+}
+
+// RefreshSRVDestinations resolves the SRV destinations of the running subscriptions again, the writers
+// of the subscriptions whose targets are added or removed are recreated like UpdateWriters does.
+// the records are looked up without s.updateLock, so the lookups do not hold up the updates of the subscriptions
+func (s *SubscriberManager) RefreshSRVDestinations() {
+	s.updateLock.Lock()
+	var changes []subscriptionChange
+	for key, sub := range s.running {
+		if hasSRVDestination(sub.Destinations) {
+			changes = append(changes, subscriptionChange{key: key, sub: sub})
+		}
+	}
+	s.updateLock.Unlock()
+
+	for i := range changes {
+		c := &changes[i]
+		resolved, origins, err := s.resolveDestinations(c.sub.Destinations)
+		if err != nil {
+			// keep the running writer until the records are back
+			s.Logger.Warn("fail to refresh SRV destinations", zap.String("db", c.key.db), zap.String("rp", c.key.rp),
+				zap.String("sub", c.key.name), zap.Error(err))
+			continue
+		}
+		c.resolved, c.origins = resolved, origins
+	}
+
+	s.updateLock.Lock()
+	defer s.updateLock.Unlock()
+	modified := changes[:0]
+	for _, c := range changes {
+		// the subscription may have been modified or removed by an update during the lookups
+		if sub, ok := s.running[c.key]; !ok || subscriptionModified(sub, c.sub) || c.resolved == nil {
+			continue
+		}
+		if !sameDestinations(c.resolved, s.writerDestinations(c.key)) {
+			modified = append(modified, c)
+		}
+	}
+	s.applyChanges(modified)
+}
+
+// writerDestinations returns the destinations of the clients of the running writer of the subscription key
+func (s *SubscriberManager) writerDestinations(key subscriptionKey) []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, w := range s.writers[key.db][key.rp] {
+		if w.Name() == key.name {
+			return writerDestinations(w)
+		}
+	}
+	return nil
+}
+
+// sameDestinations reports whether a and b hold the same destinations regardless of the order
+func sameDestinations(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	b = sortDestinations(b)
+	for i, dest := range sortDestinations(a) {
+		if b[i] != dest {
+			return false
+		}
+	}
+	return true
+}
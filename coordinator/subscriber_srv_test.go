@@ -0,0 +1,252 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openGemini/openGemini/lib/config"
+	"github.com/openGemini/openGemini/lib/errno"
+	"github.com/openGemini/openGemini/lib/logger"
+	"github.com/openGemini/openGemini/open_src/influx/meta"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubResolver returns the SRV records set for each name
+type stubResolver struct {
+	lock    sync.Mutex
+	records map[string][]*net.SRV
+}
+
+func (r *stubResolver) set(name string, records ...*net.SRV) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.records[name] = records
+}
+
+func (r *stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	records, ok := r.records[name]
+	if !ok {
+		return "", nil, errors.New("no such host")
+	}
+	return name, records, nil
+}
+
+func TestResolveDestinations(t *testing.T) {
+	resolver := &stubResolver{records: make(map[string][]*net.SRV)}
+	resolver.set("_influxdb._tcp.example.com", &net.SRV{Target: "node1.example.com.", Port: 8086},
+		&net.SRV{Target: "node2.example.com.", Port: 8087})
+	s := NewSubscriberManager(config.NewSubscriber(), &MockSubscriberMetaClient{}, logger.NewLogger(errno.ModuleCoordinator))
+	s.resolver = resolver
+
+	resolved, origins, err := s.resolveDestinations([]string{"https+srv://_influxdb._tcp.example.com/prefix?rp=raw", "http://127.0.0.1:8086"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://node1.example.com:8086/prefix?rp=raw", "https://node2.example.com:8087/prefix?rp=raw",
+		"http://127.0.0.1:8086"}, resolved)
+	assert.Equal(t, map[string]string{
+		"https://node1.example.com:8086/prefix?rp=raw": "https+srv://_influxdb._tcp.example.com/prefix?rp=raw",
+		"https://node2.example.com:8087/prefix?rp=raw": "https+srv://_influxdb._tcp.example.com/prefix?rp=raw",
+	}, origins)
+
+	_, _, err = s.resolveDestinations([]string{"http+srv://_unknown._tcp.example.com"})
+	assert.EqualError(t, err, "fail to look up SRV records of http+srv://_unknown._tcp.example.com: no such host")
+	resolver.set("_empty._tcp.example.com")
+	_, _, err = s.resolveDestinations([]string{"http+srv://_empty._tcp.example.com"})
+	assert.EqualError(t, err, "no SRV record of http+srv://_empty._tcp.example.com")
+}
+
+func TestSRVDestinations(t *testing.T) {
+	ch := make(chan string, 10)
+	newServer := func() (*httptest.Server, *net.SRV) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ioutil.ReadAll(r.Body)
+			ch <- r.Host
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		u, _ := url.Parse(server.URL)
+		port, _ := strconv.Atoi(u.Port())
+		return server, &net.SRV{Target: u.Hostname() + ".", Port: uint16(port)}
+	}
+	server1, target1 := newServer()
+	defer server1.Close()
+	server2, target2 := newServer()
+	defer server2.Close()
+	resolver := &stubResolver{records: make(map[string][]*net.SRV)}
+	resolver.set("_influxdb._tcp.local", target1, target2)
+
+	client := &MockSubscriberMetaClient{databases: make(map[string]*meta.DatabaseInfo)}
+	client.CreateSubscription("db0", "rp0", "sub0", "ALL", []string{"http+srv://_influxdb._tcp.local"})
+	s := NewSubscriberManager(config.NewSubscriber(), client, logger.NewLogger(errno.ModuleCoordinator))
+	s.resolver = resolver
+	s.InitWriters()
+	defer s.StopAllWriters()
+	// receive returns the hosts the next n writes arrive at
+	receive := func(n int) map[string]bool {
+		hosts := make(map[string]bool)
+		for i := 0; i < n; i++ {
+			select {
+			case host := <-ch:
+				hosts[host] = true
+			case <-time.After(5 * time.Second):
+				t.Fatal("write is not forwarded")
+			}
+		}
+		return hosts
+	}
+
+	// a client per target
+	s.Send("db0", "rp0", "", []byte("cpu value=1"))
+	assert.Equal(t, map[string]bool{server1.Listener.Addr().String(): true, server2.Listener.Addr().String(): true}, receive(2))
+
+	// the writer follows the removed target
+	resolver.set("_influxdb._tcp.local", target2)
+	s.RefreshSRVDestinations()
+	assert.Equal(t, 1, len(s.writers["db0"]["rp0"][0].Clients()))
+	s.Send("db0", "rp0", "", []byte("cpu value=2"))
+	assert.Equal(t, map[string]bool{server2.Listener.Addr().String(): true}, receive(1))
+
+	// the writer is kept while the records can not be looked up, and when they do not change
+	writer := s.writers["db0"]["rp0"][0]
+	resolver.lock.Lock()
+	delete(resolver.records, "_influxdb._tcp.local")
+	resolver.lock.Unlock()
+	s.RefreshSRVDestinations()
+	resolver.set("_influxdb._tcp.local", target2)
+	s.RefreshSRVDestinations()
+	assert.True(t, writer == s.writers["db0"]["rp0"][0])
+}
+
+// blockingResolver blocks the SRV lookups until release is closed
+type blockingResolver struct {
+	stubResolver
+	looking chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	select {
+	case r.looking <- struct{}{}:
+	default:
+	}
+	<-r.release
+	return r.stubResolver.LookupSRV(ctx, service, proto, name)
+}
+
+func TestInitWritersSRVLookupUnlocked(t *testing.T) {
+	resolver := &blockingResolver{stubResolver: stubResolver{records: make(map[string][]*net.SRV)},
+		looking: make(chan struct{}, 1), release: make(chan struct{})}
+	resolver.set("_influxdb._tcp.local", &net.SRV{Target: "127.0.0.1.", Port: 8086})
+	client := &MockSubscriberMetaClient{databases: make(map[string]*meta.DatabaseInfo)}
+	client.CreateSubscription("db0", "rp0", "sub0", "ALL", []string{"http+srv://_influxdb._tcp.local"})
+	s := NewSubscriberManager(config.NewSubscriber(), client, logger.NewLogger(errno.ModuleCoordinator))
+	s.resolver = resolver
+	done := make(chan struct{})
+	go func() {
+		s.InitWriters()
+		close(done)
+	}()
+	defer s.StopAllWriters()
+
+	// the writes are not blocked by the lookup
+	<-resolver.looking
+	s.Send("db0", "rp0", "", []byte("cpu value=1"))
+	assert.Equal(t, 0, len(s.Stats().Subscriptions))
+	close(resolver.release)
+	<-done
+	assert.Equal(t, []string{"http://127.0.0.1:8086"}, s.writerDestinations(subscriptionKey{db: "db0", rp: "rp0", name: "sub0"}))
+}
+
+func TestSRVDestinationSettings(t *testing.T) {
+	ch := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		ch <- r.Header.Get("X-Tenant")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	resolver := &stubResolver{records: make(map[string][]*net.SRV)}
+	resolver.set("_influxdb._tcp.local", &net.SRV{Target: u.Hostname() + ".", Port: uint16(port)})
+
+	client := &MockSubscriberMetaClient{databases: make(map[string]*meta.DatabaseInfo)}
+	client.CreateSubscription("db0", "rp0", "sub0", "ALL", []string{"http+srv://_influxdb._tcp.local"})
+	conf := config.NewSubscriber()
+	conf.Destinations = []config.DestinationConfig{{URL: "http+srv://_influxdb._tcp.local", Headers: map[string]string{"X-Tenant": "tenant0"}}}
+	s := NewSubscriberManager(conf, client, logger.NewLogger(errno.ModuleCoordinator))
+	s.resolver = resolver
+	s.InitWriters()
+	defer s.StopAllWriters()
+
+	// the targets resolved from the SRV records take the settings of the http+srv destination
+	s.Send("db0", "rp0", "", []byte("cpu value=1"))
+	select {
+	case tenant := <-ch:
+		assert.Equal(t, "tenant0", tenant)
+	case <-time.After(5 * time.Second):
+		t.Fatal("write is not forwarded")
+	}
+}
+
+func TestRefreshSRVLookupUnlocked(t *testing.T) {
+	resolver := &stubResolver{records: make(map[string][]*net.SRV)}
+	resolver.set("_influxdb._tcp.local", &net.SRV{Target: "127.0.0.1.", Port: 8086})
+	client := &MockSubscriberMetaClient{databases: make(map[string]*meta.DatabaseInfo)}
+	client.CreateSubscription("db0", "rp0", "sub0", "ALL", []string{"http+srv://_influxdb._tcp.local"})
+	s := NewSubscriberManager(config.NewSubscriber(), client, logger.NewLogger(errno.ModuleCoordinator))
+	s.resolver = resolver
+	s.InitWriters()
+	defer s.StopAllWriters()
+
+	blocking := &blockingResolver{stubResolver: stubResolver{records: make(map[string][]*net.SRV)},
+		looking: make(chan struct{}, 1), release: make(chan struct{})}
+	blocking.set("_influxdb._tcp.local", &net.SRV{Target: "127.0.0.1.", Port: 8087})
+	s.resolver = blocking
+	done := make(chan struct{})
+	go func() {
+		s.RefreshSRVDestinations()
+		close(done)
+	}()
+
+	// the updates of the subscriptions are not blocked by the lookup
+	<-blocking.looking
+	updated := make(chan struct{})
+	go func() {
+		s.UpdateWriters()
+		close(updated)
+	}()
+	select {
+	case <-updated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("update is blocked by the SRV lookup")
+	}
+	close(blocking.release)
+	<-done
+	assert.Equal(t, []string{"http://127.0.0.1:8087"}, s.writerDestinations(subscriptionKey{db: "db0", rp: "rp0", name: "sub0"}))
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// DestinationStatus is the snapshot of the statistics of a destination of a subscription
+type DestinationStatus struct {
+	Destination      string `json:"destination"`
+	LastWriteSuccess int64  `json:"lastWriteSuccessNs"`
+	WriteBytes       int64  `json:"writeBytes"`
+	WireBytes        int64  `json:"wireBytes"`
+	DroppedBytes     int64  `json:"droppedBytes"`
+}
+
+// SubscriptionStatus is the snapshot of the statistics of a subscription and its destinations
+type SubscriptionStatus struct {
+	Database        string `json:"database"`
+	RetentionPolicy string `json:"retentionPolicy"`
+	Name            string `json:"name"`
+	Mode            string `json:"mode"`
+	RemovedTags     int64  `json:"removedTags"`
+	RemovedFields   int64  `json:"removedFields"`
+	DroppedPoints   int64  `json:"droppedPoints"`
+	DroppedStale    int64  `json:"droppedStale"`
+	Unmatched       int64  `json:"unmatched"`
+	DroppedOnFull   int64  `json:"droppedOnFull"`
+	TimedOutOnFull  int64  `json:"timedOutOnFull"`
+	DroppedOnStop   int64  `json:"droppedOnStop"`
+	SkippedEmpty    int64  `json:"skippedEmpty"`
+	PartialFanOuts  int64  `json:"partialFanOuts"`
+	FailedFanOuts   int64  `json:"failedFanOuts"`
+	Writes          int64  `json:"writes"`
+	FailedWrites    int64  `json:"failedWrites"`
+	WriteNs         int64  `json:"writeNs"`
+	EnqueueWaits    int64  `json:"enqueueWaits"`
+	EnqueueWaitNs   int64  `json:"enqueueWaitNs"`
+	// Workers is the number of worker goroutines running, Concurrency is the number of them started,
+	// they only differ while the writer is stopping or idle
+	Workers      int                 `json:"workers"`
+	Concurrency  int                 `json:"concurrency"`
+	Destinations []DestinationStatus `json:"destinations"`
+	// RecentMeasurements is only tracked if recent-measurements is configured
+	RecentMeasurements []MeasurementActivity `json:"recentMeasurements,omitempty"`
+}
+
+// StatusTotals aggregates the statistics of all the subscriptions
+type StatusTotals struct {
+	Subscriptions  int   `json:"subscriptions"`
+	Destinations   int   `json:"destinations"`
+	WriteBytes     int64 `json:"writeBytes"`
+	WireBytes      int64 `json:"wireBytes"`
+	DroppedBytes   int64 `json:"droppedBytes"`
+	RemovedTags    int64 `json:"removedTags"`
+	RemovedFields  int64 `json:"removedFields"`
+	DroppedPoints  int64 `json:"droppedPoints"`
+	DroppedStale   int64 `json:"droppedStale"`
+	Unmatched      int64 `json:"unmatched"`
+	DroppedOnFull  int64 `json:"droppedOnFull"`
+	TimedOutOnFull int64 `json:"timedOutOnFull"`
+	DroppedOnStop  int64 `json:"droppedOnStop"`
+	SkippedEmpty   int64 `json:"skippedEmpty"`
+	PartialFanOuts int64 `json:"partialFanOuts"`
+	FailedFanOuts  int64 `json:"failedFanOuts"`
+	Writes         int64 `json:"writes"`
+	FailedWrites   int64 `json:"failedWrites"`
+	WriteNs        int64 `json:"writeNs"`
+	EnqueueWaits   int64 `json:"enqueueWaits"`
+	EnqueueWaitNs  int64 `json:"enqueueWaitNs"`
+	Workers        int   `json:"workers"`
+}
+
+// SubscriberStatus is the snapshot of the statistics of the subscriber service,
+// it is marshaled to JSON with stable field names to be served by an admin http handler
+type SubscriberStatus struct {
+	Totals        StatusTotals         `json:"totals"`
+	Subscriptions []SubscriptionStatus `json:"subscriptions"`
+}
+
+func newSubscriptionStatus(db, rp string, w SubscriberWriter) SubscriptionStatus {
+	sStats := w.Stats()
+	status := SubscriptionStatus{
+		Database:        db,
+		RetentionPolicy: rp,
+		Name:            w.Name(),
+		Mode:            w.Mode(),
+		RemovedTags:     atomic.LoadInt64(&sStats.RemovedTags),
+		RemovedFields:   atomic.LoadInt64(&sStats.RemovedFields),
+		DroppedPoints:   atomic.LoadInt64(&sStats.DroppedPoints),
+		DroppedStale:    atomic.LoadInt64(&sStats.DroppedStale),
+		Unmatched:       atomic.LoadInt64(&sStats.Unmatched),
+		DroppedOnFull:   atomic.LoadInt64(&sStats.DroppedOnFull),
+		TimedOutOnFull:  atomic.LoadInt64(&sStats.TimedOutOnFull),
+		DroppedOnStop:   atomic.LoadInt64(&sStats.DroppedOnStop),
+		SkippedEmpty:    atomic.LoadInt64(&sStats.SkippedEmpty),
+		PartialFanOuts:  atomic.LoadInt64(&sStats.PartialFanOuts),
+		FailedFanOuts:   atomic.LoadInt64(&sStats.FailedFanOuts),
+		Writes:          atomic.LoadInt64(&sStats.Writes),
+		FailedWrites:    atomic.LoadInt64(&sStats.FailedWrites),
+		WriteNs:         atomic.LoadInt64(&sStats.WriteNs),
+		EnqueueWaits:    atomic.LoadInt64(&sStats.EnqueueWaits),
+		EnqueueWaitNs:   atomic.LoadInt64(&sStats.EnqueueWaitNs),
+		Destinations:    make([]DestinationStatus, 0, len(w.Clients())),
+
+		RecentMeasurements: w.RecentMeasurements(),
+	}
+	status.Workers, status.Concurrency = w.Workers()
+	for _, c := range w.Clients() {
+		stats := c.Stats()
+		status.Destinations = append(status.Destinations, DestinationStatus{
+			Destination:      redactDestination(c.Destination()),
+			LastWriteSuccess: atomic.LoadInt64(&stats.LastWriteSuccess),
+			WriteBytes:       atomic.LoadInt64(&stats.WriteBytes),
+			WireBytes:        atomic.LoadInt64(&stats.WireBytes),
+			DroppedBytes:     atomic.LoadInt64(&stats.DroppedBytes),
+		})
+	}
+	return status
+}
+
+// Stats returns a snapshot of the statistics of all the subscriptions sorted by db, rp and name,
+// the snapshot is taken under the lock so that no writer is added or removed in the meantime
+func (s *SubscriberManager) Stats() SubscriberStatus {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	status := SubscriberStatus{Subscriptions: make([]SubscriptionStatus, 0)}
+	for db, rps := range s.writers {
+		for rp, writers := range rps {
+			for _, w := range writers {
+				status.Subscriptions = append(status.Subscriptions, newSubscriptionStatus(db, rp, w))
+			}
+		}
+	}
+	sort.Slice(status.Subscriptions, func(i, j int) bool {
+		a, b := status.Subscriptions[i], status.Subscriptions[j]
+		if a.Database != b.Database {
+			return a.Database < b.Database
+		}
+		if a.RetentionPolicy != b.RetentionPolicy {
+			return a.RetentionPolicy < b.RetentionPolicy
+		}
+		return a.Name < b.Name
+	})
+
+	totals := &status.Totals
+	for _, sub := range status.Subscriptions {
+		totals.Subscriptions++
+		totals.RemovedTags += sub.RemovedTags
+		totals.RemovedFields += sub.RemovedFields
+		totals.DroppedPoints += sub.DroppedPoints
+		totals.DroppedStale += sub.DroppedStale
+		totals.Unmatched += sub.Unmatched
+		totals.DroppedOnFull += sub.DroppedOnFull
+		totals.TimedOutOnFull += sub.TimedOutOnFull
+		totals.DroppedOnStop += sub.DroppedOnStop
+		totals.SkippedEmpty += sub.SkippedEmpty
+		totals.PartialFanOuts += sub.PartialFanOuts
+		totals.FailedFanOuts += sub.FailedFanOuts
+		totals.Writes += sub.Writes
+		totals.FailedWrites += sub.FailedWrites
+		totals.WriteNs += sub.WriteNs
+		totals.EnqueueWaits += sub.EnqueueWaits
+		totals.EnqueueWaitNs += sub.EnqueueWaitNs
+		totals.Workers += sub.Workers
+		for _, d := range sub.Destinations {
+			totals.Destinations++
+			totals.WriteBytes += d.WriteBytes
+			totals.WireBytes += d.WireBytes
+			totals.DroppedBytes += d.DroppedBytes
+		}
+	}
+	return status
+}
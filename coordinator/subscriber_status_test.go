@@ -0,0 +1,220 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openGemini/openGemini/lib/config"
+	"github.com/openGemini/openGemini/lib/errno"
+	"github.com/openGemini/openGemini/lib/logger"
+	"github.com/openGemini/openGemini/open_src/influx/meta"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriberStatusJSON(t *testing.T) {
+	client := &MockSubscriberMetaClient{databases: make(map[string]*meta.DatabaseInfo)}
+	client.CreateSubscription("db0", "rp0", "sub1", "ANY", []string{"http://127.0.0.1:8087", "http://127.0.0.1:8086"})
+	client.CreateSubscription("db0", "rp0", "sub0", "ALL", []string{"http://127.0.0.1:8088"})
+	conf := config.NewSubscriber()
+	conf.WriteConcurrency = 2
+	s := NewSubscriberManager(conf, client, logger.NewLogger(errno.ModuleCoordinator))
+	s.InitWriters()
+	defer s.StopAllWriters()
+
+	for _, w := range s.writers["db0"]["rp0"] {
+		w.Stats().DroppedPoints = 1
+		for i, c := range w.Clients() {
+			c.Stats().SetLastWriteSuccess(int64(i + 1))
+			c.Stats().AddBytes(100, 40)
+		}
+	}
+
+	b, err := json.Marshal(s.Stats())
+	assert.NoError(t, err)
+	exp := `{"totals":{"subscriptions":2,"destinations":3,"writeBytes":300,"wireBytes":120,"droppedBytes":0,"removedTags":0,"removedFields":0,"droppedPoints":2,"droppedStale":0,"unmatched":0,"droppedOnFull":0,"timedOutOnFull":0,"droppedOnStop":0,"skippedEmpty":0,"partialFanOuts":0,"failedFanOuts":0,"writes":0,"failedWrites":0,"writeNs":0,"enqueueWaits":0,"enqueueWaitNs":0,"workers":4},` +
+		`"subscriptions":[` +
+		`{"database":"db0","retentionPolicy":"rp0","name":"sub0","mode":"ALL","removedTags":0,"removedFields":0,"droppedPoints":1,"droppedStale":0,"unmatched":0,"droppedOnFull":0,"timedOutOnFull":0,"droppedOnStop":0,"skippedEmpty":0,"partialFanOuts":0,"failedFanOuts":0,"writes":0,"failedWrites":0,"writeNs":0,"enqueueWaits":0,"enqueueWaitNs":0,"workers":2,"concurrency":2,"destinations":[` +
+		`{"destination":"http://127.0.0.1:8088","lastWriteSuccessNs":1,"writeBytes":100,"wireBytes":40,"droppedBytes":0}]},` +
+		`{"database":"db0","retentionPolicy":"rp0","name":"sub1","mode":"ANY","removedTags":0,"removedFields":0,"droppedPoints":1,"droppedStale":0,"unmatched":0,"droppedOnFull":0,"timedOutOnFull":0,"droppedOnStop":0,"skippedEmpty":0,"partialFanOuts":0,"failedFanOuts":0,"writes":0,"failedWrites":0,"writeNs":0,"enqueueWaits":0,"enqueueWaitNs":0,"workers":2,"concurrency":2,"destinations":[` +
+		`{"destination":"http://127.0.0.1:8086","lastWriteSuccessNs":1,"writeBytes":100,"wireBytes":40,"droppedBytes":0},` +
+		`{"destination":"http://127.0.0.1:8087","lastWriteSuccessNs":2,"writeBytes":100,"wireBytes":40,"droppedBytes":0}]}]}`
+	assert.Equal(t, exp, string(b))
+
+	// no subscriptions
+	s = NewSubscriberManager(config.NewSubscriber(), &MockSubscriberMetaClient{databases: make(map[string]*meta.DatabaseInfo)},
+		logger.NewLogger(errno.ModuleCoordinator))
+	b, err = json.Marshal(s.Stats())
+	assert.NoError(t, err)
+	assert.Equal(t, `{"totals":{"subscriptions":0,"destinations":0,"writeBytes":0,"wireBytes":0,"droppedBytes":0,"removedTags":0,"removedFields":0,"droppedPoints":0,"droppedStale":0,"unmatched":0,"droppedOnFull":0,"timedOutOnFull":0,"droppedOnStop":0,"skippedEmpty":0,"partialFanOuts":0,"failedFanOuts":0,"writes":0,"failedWrites":0,"writeNs":0,"enqueueWaits":0,"enqueueWaitNs":0,"workers":0},"subscriptions":[]}`, string(b))
+}
+
+func TestResetStats(t *testing.T) {
+	client := &MockSubscriberMetaClient{databases: make(map[string]*meta.DatabaseInfo)}
+	client.CreateSubscription("db0", "rp0", "sub0", "ALL", []string{"http://127.0.0.1:8086", "http://127.0.0.1:8087"})
+	s := NewSubscriberManager(config.NewSubscriber(), client, logger.NewLogger(errno.ModuleCoordinator))
+	s.InitWriters()
+	defer s.StopAllWriters()
+
+	w := s.writers["db0"]["rp0"][0]
+	c := w.Clients()[0]
+	const workers, updates = 4, 1000
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < updates; j++ {
+				c.Stats().AddBytes(2, 1)
+			}
+		}()
+	}
+	// the counters swapped out by the resets and the remaining ones add up to all the updates
+	var writeBytes, wireBytes int64
+	for i := 0; i < 10; i++ {
+		old := c.Stats().Reset()
+		writeBytes += old.WriteBytes
+		wireBytes += old.WireBytes
+	}
+	wg.Wait()
+	old := c.Stats().Reset()
+	assert.Equal(t, int64(2*workers*updates), writeBytes+old.WriteBytes)
+	assert.Equal(t, int64(workers*updates), wireBytes+old.WireBytes)
+
+	w.Stats().DroppedPoints = 3
+	w.Stats().RemovedTags = 2
+	for _, c := range w.Clients() {
+		c.Stats().SetLastWriteSuccess(5)
+		c.Stats().AddBytes(100, 40)
+	}
+	totals := s.Stats().Totals
+	assert.Equal(t, int64(200), totals.WriteBytes)
+	assert.Equal(t, int64(3), totals.DroppedPoints)
+
+	s.ResetStats()
+	status := s.Stats()
+	// the timestamp of the last successful write and the running workers are not counters
+	running, _ := w.Workers()
+	assert.Equal(t, StatusTotals{Subscriptions: 1, Destinations: 2, Workers: running}, status.Totals)
+	for _, dest := range status.Subscriptions[0].Destinations {
+		assert.Equal(t, int64(5), dest.LastWriteSuccess)
+	}
+}
+
+func TestSubscriberStatusRecentMeasurements(t *testing.T) {
+	client := &MockSubscriberMetaClient{databases: make(map[string]*meta.DatabaseInfo)}
+	client.CreateSubscription("db0", "rp0", "sub0", "ALL", []string{"http://127.0.0.1:8086", "http://127.0.0.1:8087"})
+	conf := config.NewSubscriber()
+	conf.RecentMeasurements = 10
+	conf.Subscriptions = []config.SubscriptionConfig{{Database: "db0", Name: "sub0", DenyFields: []string{"debug"}}}
+	s := NewSubscriberManager(conf, client, logger.NewLogger(errno.ModuleCoordinator))
+	s.InitWriters()
+	defer s.StopAllWriters()
+
+	w := s.writers["db0"]["rp0"][0]
+	w.Write("", "", []byte("cpu,host=server01 value=1\nmem,host=server01 free=3i\ncpu,host=server02 value=2\n"))
+	// the points dropped by the filter are not forwarded
+	w.Write("", "", []byte("cpu,host=server03 value=3\ndisk,host=server01 debug=1\n"))
+	// the measurements are tracked by the workers, once for all the destinations
+	var recent []MeasurementActivity
+	assert.Eventually(t, func() bool {
+		recent = s.Stats().Subscriptions[0].RecentMeasurements
+		return len(recent) == 2 && recent[0].Points == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, len(recent))
+	assert.Equal(t, "cpu", recent[0].Measurement)
+	assert.Equal(t, int64(3), recent[0].Points)
+	assert.Equal(t, "mem", recent[1].Measurement)
+	assert.Equal(t, int64(1), recent[1].Points)
+}
+
+func TestSubscriberStatusWorkers(t *testing.T) {
+	client := &MockSubscriberMetaClient{databases: make(map[string]*meta.DatabaseInfo)}
+	client.CreateSubscription("db0", "rp0", "sub0", "ALL", []string{"http://127.0.0.1:8088"})
+	conf := config.NewSubscriber()
+	conf.WriteConcurrency = 3
+	sc := config.NewSubscriptionConfig()
+	sc.Database, sc.Name, sc.WriteConcurrency = "db0", "sub1", 5
+	conf.Subscriptions = []config.SubscriptionConfig{sc}
+	s := NewSubscriberManager(conf, client, logger.NewLogger(errno.ModuleCoordinator))
+	s.InitWriters()
+
+	status := s.Stats()
+	assert.Equal(t, 3, status.Subscriptions[0].Workers)
+	assert.Equal(t, 3, status.Subscriptions[0].Concurrency)
+
+	// the recreated writer reports its own workers
+	_ = client.DropSubscription("db0", "rp0", "sub0")
+	client.CreateSubscription("db0", "rp0", "sub1", "ALL", []string{"http://127.0.0.1:8088"})
+	s.UpdateWriters()
+	status = s.Stats()
+	assert.Equal(t, 1, len(status.Subscriptions))
+	assert.Equal(t, 5, status.Subscriptions[0].Workers)
+	assert.Equal(t, 5, status.Totals.Workers)
+
+	// the workers exit once the writer is stopped
+	w := s.writers["db0"]["rp0"][0]
+	assert.True(t, s.Shutdown(5*time.Second))
+	running, started := w.Workers()
+	assert.Equal(t, 0, running)
+	assert.Equal(t, 5, started)
+}
+
+func TestSubscriberStatusRedactsDestination(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	dest := strings.Replace(server.URL, "http://", "http://u:secret@", 1) + "?rp=raw&token=secret"
+	client := &MockSubscriberMetaClient{databases: make(map[string]*meta.DatabaseInfo)}
+	client.CreateSubscription("db0", "rp0", "sub0", "ALL", []string{dest})
+	s := NewSubscriberManager(config.NewSubscriber(), client, logger.NewLogger(errno.ModuleCoordinator))
+	observer := make(chanWriteObserver, 1)
+	s.RegisterWriteObserver(observer)
+	failures := make(chan *WriteFailure, 1)
+	s.RegisterWriteFailureHook(func(f *WriteFailure) {
+		select {
+		case failures <- f:
+		default:
+		}
+	})
+	s.Start(context.Background())
+	defer s.Stop()
+
+	s.Send("db0", "rp0", "", []byte("cpu value=1"))
+	e := <-observer
+	assert.NotContains(t, e.Destination, "secret")
+	f := <-failures
+	assert.NotContains(t, f.Destination, "secret")
+	assert.Equal(t, e.Destination, f.Destination)
+
+	b, err := json.Marshal(s.Stats())
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "secret")
+	assert.Contains(t, string(b), "rp=raw")
+
+	results, err := s.TestSubscription("db0", "rp0", "sub0")
+	assert.NoError(t, err)
+	assert.NotContains(t, results[0].Destination, "secret")
+}
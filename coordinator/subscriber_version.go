@@ -0,0 +1,85 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/openGemini/openGemini/lib/config"
+	"github.com/openGemini/openGemini/lib/logger"
+	"go.uber.org/zap"
+)
+
+// versionHeaders are the ping response headers carrying the version of a destination,
+// openGemini reports X-Geminidb-Version and influxdb reports X-Influxdb-Version
+var versionHeaders = []string{"X-Geminidb-Version", "X-Influxdb-Version"}
+
+// Version pings the destination and returns the version it reports, empty if it reports none
+func (c *HTTPClient) Version() (string, error) {
+	resp, err := c.get("/ping")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected ping status %s", resp.Status)
+	}
+	for _, h := range versionHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			return v, nil
+		}
+	}
+	return "", nil
+}
+
+// compareVersions returns -1, 0 or 1 if a is older than, the same as or newer than b
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] < b[i] {
+			return -1
+		}
+		if a[i] > b[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// checkVersion rejects c if the version it reports is older than min. the destination may be down
+// or not be an openGemini at the moment, so an unknown version is only logged
+func checkVersion(c *HTTPClient, min string, log *logger.Logger) error {
+	minVersion, err := config.ParseVersion(min)
+	if err != nil {
+		return err
+	}
+	v, err := c.Version()
+	if err != nil {
+		log.Warn("fail to get the version of destination", zap.String("destination", c.url.Redacted()), zap.Error(err))
+		return nil
+	}
+	version, err := config.ParseVersion(v)
+	if err != nil {
+		log.Warn("unknown version of destination", zap.String("destination", c.url.Redacted()), zap.String("version", v))
+		return nil
+	}
+	if compareVersions(version, minVersion) < 0 {
+		return fmt.Errorf("version %s of destination %s is older than min-destination-version %s", v, c.url.Redacted(), min)
+	}
+	return nil
+}
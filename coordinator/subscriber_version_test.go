@@ -0,0 +1,122 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openGemini/openGemini/lib/config"
+	"github.com/openGemini/openGemini/lib/errno"
+	"github.com/openGemini/openGemini/lib/logger"
+	"github.com/openGemini/openGemini/open_src/influx/meta"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVersion(t *testing.T) {
+	for v, expected := range map[string][3]int{
+		"1.1.0":      {1, 1, 0},
+		"v1.2.3":     {1, 2, 3},
+		"1.2.0-rc1":  {1, 2, 0},
+		"1.8":        {1, 8, 0},
+		"2":          {2, 0, 0},
+		"1.0.0+abcd": {1, 0, 0},
+	} {
+		parsed, err := config.ParseVersion(v)
+		assert.NoError(t, err, v)
+		assert.Equal(t, expected, parsed, v)
+	}
+	for _, v := range []string{"", "latest", "1.2.3.4", "1.x.0", "1.-1.0"} {
+		_, err := config.ParseVersion(v)
+		assert.EqualError(t, err, "invalid version "+v)
+	}
+	assert.Equal(t, -1, compareVersions([3]int{1, 1, 9}, [3]int{1, 2, 0}))
+	assert.Equal(t, 0, compareVersions([3]int{1, 2, 0}, [3]int{1, 2, 0}))
+	assert.Equal(t, 1, compareVersions([3]int{2, 0, 0}, [3]int{1, 9, 9}))
+}
+
+func TestMinDestinationVersion(t *testing.T) {
+	newServer := func(header, version string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if header != "" {
+				w.Header().Set(header, version)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	}
+	older := newServer("X-Geminidb-Version", "v1.0.1")
+	defer older.Close()
+	newer := newServer("X-Geminidb-Version", "v1.2.0")
+	defer newer.Close()
+	influxdb := newServer("X-Influxdb-Version", "1.8.10")
+	defer influxdb.Close()
+	unknown := newServer("", "")
+	defer unknown.Close()
+	down := newServer("", "")
+	down.Close()
+
+	c := config.NewSubscriber()
+	c.MinDestinationVersion = "1.1.0"
+	s := NewSubscriberManager(c, &MockSubscriberMetaClient{}, logger.NewLogger(errno.ModuleCoordinator))
+
+	_, err := s.NewSubscriberWriter("db0", "rp0", "sub0", "ALL", []string{newer.URL, older.URL})
+	assert.EqualError(t, err, "version v1.0.1 of destination "+older.URL+" is older than min-destination-version 1.1.0")
+
+	// the unknown versions are only logged
+	w, err := s.NewSubscriberWriter("db0", "rp0", "sub0", "ALL", []string{newer.URL, influxdb.URL, unknown.URL, down.URL})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(w.Clients()))
+
+	// the check is disabled by default
+	s = NewSubscriberManager(config.NewSubscriber(), &MockSubscriberMetaClient{}, logger.NewLogger(errno.ModuleCoordinator))
+	_, err = s.NewSubscriberWriter("db0", "rp0", "sub0", "ALL", []string{older.URL})
+	assert.NoError(t, err)
+}
+
+func TestInitWritersCheckVersionUnlocked(t *testing.T) {
+	pinged, release := make(chan struct{}, 1), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case pinged <- struct{}{}:
+		default:
+		}
+		<-release
+		w.Header().Set("X-Geminidb-Version", "v1.2.0")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := &MockSubscriberMetaClient{databases: make(map[string]*meta.DatabaseInfo)}
+	client.CreateSubscription("db0", "rp0", "sub0", "ALL", []string{server.URL})
+	c := config.NewSubscriber()
+	c.MinDestinationVersion = "1.1.0"
+	s := NewSubscriberManager(c, client, logger.NewLogger(errno.ModuleCoordinator))
+	done := make(chan struct{})
+	go func() {
+		s.InitWriters()
+		close(done)
+	}()
+	defer s.StopAllWriters()
+
+	// the writers are read while the version of the destination is being checked
+	<-pinged
+	assert.Equal(t, 0, len(s.Stats().Subscriptions))
+	close(release)
+	<-done
+	assert.Equal(t, 1, len(s.Stats().Subscriptions))
+}
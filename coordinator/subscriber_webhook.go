@@ -0,0 +1,257 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/openGemini/openGemini/lib/statisticsPusher/statistics"
+)
+
+// DefaultWebhookTemplate is the template of the webhook destinations without webhook-template configured
+const DefaultWebhookTemplate = `{"measurement":"$measurement","tags":"$tags","fields":"$fields","time":"$time"}`
+
+// WebhookTemplate renders a point as a JSON value. It is a JSON document, where a string
+// that starts with $ is replaced by a part of the point:
+//
+//	$measurement, $db, $rp     the measurement, database and retention policy as strings
+//	$time                      the timestamp in RFC3339 with nanoseconds
+//	$timestamp                 the unix timestamp in nanoseconds
+//	$tags, $fields             all the tags or fields as an object
+//	$tag.<key>, $field.<key>   a single tag or field, null if the point does not have it
+//
+// a string that starts with $$ is kept as it is without the first $
+type WebhookTemplate struct {
+	root interface{}
+}
+
+// ParseWebhookTemplate parses the JSON template s, it returns an error on an unknown placeholder
+func ParseWebhookTemplate(s string) (*WebhookTemplate, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var root interface{}
+	if err := dec.Decode(&root); err != nil {
+		return nil, fmt.Errorf("invalid webhook template: %v", err)
+	}
+	if err := checkPlaceholders(root); err != nil {
+		return nil, err
+	}
+	return &WebhookTemplate{root: root}, nil
+}
+
+func checkPlaceholders(v interface{}) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, e := range v {
+			if err := checkPlaceholders(e); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if err := checkPlaceholders(e); err != nil {
+				return err
+			}
+		}
+	case string:
+		if !strings.HasPrefix(v, "$") || strings.HasPrefix(v, "$$") {
+			return nil
+		}
+		switch {
+		case v == "$measurement", v == "$db", v == "$rp", v == "$time", v == "$timestamp", v == "$tags", v == "$fields":
+		case strings.HasPrefix(v, "$tag.") && len(v) > len("$tag."):
+		case strings.HasPrefix(v, "$field.") && len(v) > len("$field."):
+		default:
+			return fmt.Errorf("unknown webhook template placeholder %s", v)
+		}
+	}
+	return nil
+}
+
+// Render returns the JSON value of pt written to db.rp
+func (t *WebhookTemplate) Render(db, rp string, pt models.Point) (interface{}, error) {
+	fields, err := pt.Fields()
+	if err != nil {
+		return nil, err
+	}
+	return t.render(t.root, db, rp, pt, fields), nil
+}
+
+func (t *WebhookTemplate) render(v interface{}, db, rp string, pt models.Point, fields models.Fields) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			out[k] = t.render(e, db, rp, pt, fields)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = t.render(e, db, rp, pt, fields)
+		}
+		return out
+	case string:
+		if !strings.HasPrefix(v, "$") {
+			return v
+		}
+		switch v {
+		case "$measurement":
+			return string(pt.Name())
+		case "$db":
+			return db
+		case "$rp":
+			return rp
+		case "$time":
+			return pt.Time().UTC().Format(time.RFC3339Nano)
+		case "$timestamp":
+			return pt.UnixNano()
+		case "$tags":
+			return pt.Tags().Map()
+		case "$fields":
+			return map[string]interface{}(fields)
+		}
+		if strings.HasPrefix(v, "$$") {
+			return v[1:]
+		}
+		if key := strings.TrimPrefix(v, "$tag."); key != v {
+			if tag := pt.Tags().Get([]byte(key)); tag != nil {
+				return string(tag)
+			}
+			return nil
+		}
+		if key := strings.TrimPrefix(v, "$field."); key != v {
+			return fields[key]
+		}
+	}
+	return v
+}
+
+// Body renders the points of lineProtocol written to db.rp as a JSON array, in the order of the lines
+func (t *WebhookTemplate) Body(db, rp string, lineProtocol []byte) ([]byte, int, error) {
+	points, err := models.ParsePointsWithPrecision(lineProtocol, time.Time{}, "n")
+	if err != nil {
+		return nil, 0, err
+	}
+	values := make([]interface{}, 0, len(points))
+	for _, pt := range points {
+		v, err := t.Render(db, rp, pt)
+		if err != nil {
+			return nil, 0, err
+		}
+		values = append(values, v)
+	}
+	body, err := json.Marshal(values)
+	return body, len(points), err
+}
+
+// WebhookClient posts the points to a generic webhook as JSON rendered by a template instead of line protocol.
+// the destination is webhook://host:port/path for http and webhooks://host:port/path for https
+type WebhookClient struct {
+	client   *http.Client
+	url      *url.URL
+	endpoint string
+	template *WebhookTemplate
+	stats    *statistics.SubscriberStats
+	// nodeID is sent in nodeIDHeader with every write if the header is not empty
+	nodeIDHeader string
+	nodeID       string
+	// headers are the static headers of the destination sent with every request
+	headers map[string]string
+}
+
+func NewWebhookClient(u *url.URL, template *WebhookTemplate, timeout time.Duration, skipVerify bool, proxy *url.URL) *WebhookClient {
+	transport := newTransport(proxy)
+	endpoint := *u
+	endpoint.Scheme = "http"
+	if u.Scheme == "webhooks" {
+		endpoint.Scheme = "https"
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: skipVerify}
+	}
+	return &WebhookClient{
+		client:   &http.Client{Timeout: timeout, Transport: transport},
+		url:      u,
+		endpoint: endpoint.String(),
+		template: template,
+		stats:    statistics.NewSubscriberStats(),
+	}
+}
+
+// Send posts the points of lineProtocol as a JSON array, a 2xx status means the webhook accepts them
+func (c *WebhookClient) Send(ctx context.Context, db, rp, user string, lineProtocol []byte) error {
+	body, n, err := c.template.Body(db, rp, lineProtocol)
+	if err != nil {
+		return fmt.Errorf("fail to render webhook body: %v", err)
+	}
+	if n == 0 {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	setHeaders(req, c.headers)
+	req.Header.Set("Content-Type", "application/json")
+	if c.nodeIDHeader != "" {
+		req.Header.Set(c.nodeIDHeader, c.nodeID)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected webhook status %s: %s", resp.Status, msg)
+	}
+	c.stats.AddBytes(int64(len(lineProtocol)), int64(len(body)))
+	return nil
+}
+
+// Query does nothing, a statement has no point to render for a webhook
+func (c *WebhookClient) Query(ctx context.Context, db, q string) error {
+	return nil
+}
+
+// Ping always succeeds, a generic webhook has no endpoint to check its health
+func (c *WebhookClient) Ping() error {
+	return nil
+}
+
+// CloseIdleConnections closes the keep-alive connections to the destination, e.g. when the writer is idle
+func (c *WebhookClient) CloseIdleConnections() {
+	c.client.CloseIdleConnections()
+}
+
+func (c *WebhookClient) Destination() string {
+	return c.url.String()
+}
+
+func (c *WebhookClient) Stats() *statistics.SubscriberStats {
+	return c.stats
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openGemini/openGemini/lib/config"
+	"github.com/openGemini/openGemini/lib/errno"
+	"github.com/openGemini/openGemini/lib/logger"
+	"github.com/openGemini/openGemini/open_src/influx/meta"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookTemplateBody(t *testing.T) {
+	lines := []byte("cpu,host=server-01,region=west value=75.5,busy=true,state=\"ok\",n=3i 1700000000000000001\n" +
+		"mem,host=server-02 used=12i 1700000000000000000\n")
+	for _, c := range []struct {
+		template string
+		exp      string
+	}{
+		{
+			template: DefaultWebhookTemplate,
+			exp: `[{"measurement":"cpu","tags":{"host":"server-01","region":"west"},"fields":{"value":75.5,"busy":true,"state":"ok","n":3},"time":"2023-11-14T22:13:20.000000001Z"},` +
+				`{"measurement":"mem","tags":{"host":"server-02"},"fields":{"used":12},"time":"2023-11-14T22:13:20Z"}]`,
+		},
+		{
+			template: `{"name":"$measurement","source":"$$db","db":"$db","host":"$tag.host","region":"$tag.region",` +
+				`"value":"$field.value","ts":"$timestamp","literal":"$$tags","labels":["static",1.50,"$tag.host"]}`,
+			exp: `[{"name":"cpu","source":"$db","db":"db0","host":"server-01","region":"west","value":75.5,"ts":1700000000000000001,"literal":"$tags","labels":["static",1.50,"server-01"]},` +
+				`{"name":"mem","source":"$db","db":"db0","host":"server-02","region":null,"value":null,"ts":1700000000000000000,"literal":"$tags","labels":["static",1.50,"server-02"]}]`,
+		},
+	} {
+		tmpl, err := ParseWebhookTemplate(c.template)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		body, n, err := tmpl.Body("db0", "rp0", lines)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.JSONEq(t, c.exp, string(body), c.template)
+	}
+}
+
+func TestParseWebhookTemplate(t *testing.T) {
+	_, err := ParseWebhookTemplate(`{"name":"$name"}`)
+	assert.EqualError(t, err, "unknown webhook template placeholder $name")
+	_, err = ParseWebhookTemplate(`{"tags":["$tag."]}`)
+	assert.EqualError(t, err, "unknown webhook template placeholder $tag.")
+	_, err = ParseWebhookTemplate(`{"name":`)
+	assert.Error(t, err)
+	_, err = ParseWebhookTemplate(`"$measurement"`)
+	assert.NoError(t, err)
+}
+
+func TestWebhookClient(t *testing.T) {
+	type request struct {
+		path, contentType, body string
+	}
+	ch := make(chan request, 1)
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		ch <- request{path: r.URL.Path, contentType: r.Header.Get("Content-Type"), body: string(body)}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	dest := strings.Replace(server.URL, "http://", "webhook://", 1) + "/events"
+	client := &MockSubscriberMetaClient{databases: make(map[string]*meta.DatabaseInfo)}
+	client.CreateSubscription("db0", "rp0", "sub0", "ALL", []string{dest})
+	conf := config.NewSubscriber()
+	conf.Destinations = []config.DestinationConfig{{URL: dest, WebhookTemplate: `{"host":"$tag.host","value":"$field.value"}`}}
+	assert.NoError(t, conf.Validate())
+	s := NewSubscriberManager(conf, client, logger.NewLogger(errno.ModuleCoordinator))
+	s.InitWriters()
+	s.Send("db0", "rp0", "", []byte("cpu,host=server-01 value=1\ncpu,host=server-02 value=2"))
+	assert.True(t, s.Shutdown(5*time.Second))
+
+	select {
+	case r := <-ch:
+		assert.Equal(t, "/events", r.path)
+		assert.Equal(t, "application/json", r.contentType)
+		assert.JSONEq(t, `[{"host":"server-01","value":1},{"host":"server-02","value":2}]`, r.body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the webhook")
+	}
+
+	u, _ := url.Parse(dest)
+	tmpl, _ := ParseWebhookTemplate(DefaultWebhookTemplate)
+	c := NewWebhookClient(u, tmpl, time.Second, false, nil)
+	status = http.StatusBadRequest
+	err := c.Send(context.Background(), "db0", "rp0", "", []byte("cpu value=1"))
+	<-ch
+	assert.Error(t, err)
+	assert.NoError(t, c.Ping())
+	assert.Equal(t, dest, c.Destination())
+
+	conf.Destinations[0].WebhookTemplate = `{"host":`
+	assert.EqualError(t, conf.Validate(), "subscriber webhook-template of destination "+dest+" is not valid JSON")
+	conf.Destinations[0].WebhookTemplate = `{"host":"$host"}`
+	s = NewSubscriberManager(conf, client, logger.NewLogger(errno.ModuleCoordinator))
+	_, err = s.NewSubscriberWriter("db0", "rp0", "sub0", "ALL", []string{dest})
+	assert.EqualError(t, err, "invalid webhook-template of destination "+dest+": unknown webhook template placeholder $host")
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"sync"
+)
+
+// AdaptiveWeights picks the destinations of an ANY mode subscription by smooth weighted round-robin,
+// the weight of a destination is lowered as its error rate rises and restored as the errors subside.
+// the error rate is a moving average of the outcomes of the writes to the destination
+type AdaptiveWeights struct {
+	lock      sync.Mutex
+	decay     float64
+	minWeight float64
+	errRates  []float64
+	// current is the state of the smooth weighted round-robin, the destination with the largest one is picked
+	current []float64
+}
+
+// NewAdaptiveWeights returns the weights of n destinations, decay is the fraction of the error rate kept at each
+// write and minWeight is the lower bound of a weight, so that a failing destination still gets the writes
+// which tell when it recovers
+func NewAdaptiveWeights(n int, decay, minWeight float64) *AdaptiveWeights {
+	return &AdaptiveWeights{decay: decay, minWeight: minWeight, errRates: make([]float64, n), current: make([]float64, n)}
+}
+
+func (a *AdaptiveWeights) weight(i int) float64 {
+	w := 1 - a.errRates[i]
+	if w < a.minWeight {
+		return a.minWeight
+	}
+	return w
+}
+
+// Weight returns the effective weight of destination i between minWeight and 1
+func (a *AdaptiveWeights) Weight(i int) float64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.weight(i)
+}
+
+// Next returns the destination of the next write
+func (a *AdaptiveWeights) Next() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	best, total := 0, 0.0
+	for i := range a.current {
+		w := a.weight(i)
+		a.current[i] += w
+		total += w
+		if a.current[i] > a.current[best] {
+			best = i
+		}
+	}
+	a.current[best] -= total
+	return best
+}
+
+// Observe records the outcome of a write to destination i
+func (a *AdaptiveWeights) Observe(i int, err error) {
+	if a == nil {
+		return
+	}
+	var failed float64
+	if err != nil {
+		failed = 1
+	}
+	a.lock.Lock()
+	a.errRates[i] = a.decay*a.errRates[i] + (1-a.decay)*failed
+	a.lock.Unlock()
+}
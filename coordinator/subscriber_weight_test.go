@@ -0,0 +1,103 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coordinator
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/openGemini/openGemini/lib/config"
+	"github.com/openGemini/openGemini/lib/errno"
+	"github.com/openGemini/openGemini/lib/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveWeights(t *testing.T) {
+	a := NewAdaptiveWeights(2, 0.9, 0.1)
+	failing := false
+	// share sends n writes and returns the share of them sent to the second destination
+	share := func(n int) float64 {
+		var hits int
+		for i := 0; i < n; i++ {
+			k := a.Next()
+			var err error
+			if k == 1 {
+				hits++
+				if failing {
+					err = errors.New("unavailable")
+				}
+			}
+			a.Observe(k, err)
+		}
+		return float64(hits) / float64(n)
+	}
+	assert.Equal(t, 0.5, share(100))
+
+	// the share of the failing destination drops, but not below the min weight
+	failing = true
+	share(100)
+	assert.Less(t, share(200), 0.15)
+	assert.Equal(t, 0.1, a.Weight(1))
+	assert.Equal(t, 1.0, a.Weight(0))
+
+	// the share recovers as the writes to it succeed again
+	failing = false
+	share(300)
+	assert.Greater(t, share(200), 0.45)
+	assert.Greater(t, a.Weight(1), 0.9)
+}
+
+func TestAdaptiveWeightsConfig(t *testing.T) {
+	conf := config.NewSubscriber()
+	sc := config.NewSubscriptionConfig()
+	sc.Database, sc.Name, sc.AdaptiveWeights = "db0", "sub0", true
+	conf.Subscriptions = []config.SubscriptionConfig{sc}
+	s := NewSubscriberManager(conf, &MockSubscriberMetaClient{}, logger.NewLogger(errno.ModuleCoordinator))
+	w, err := s.NewSubscriberWriter("db0", "rp0", "sub0", "ANY", []string{"http://127.0.0.1:8086", "http://127.0.0.1:8087"})
+	assert.NoError(t, err)
+	assert.NotNil(t, w.(*RoundRobinWriter).weights)
+
+	w, err = s.NewSubscriberWriter("db0", "rp0", "sub1", "ANY", []string{"http://127.0.0.1:8086", "http://127.0.0.1:8087"})
+	assert.NoError(t, err)
+	assert.Nil(t, w.(*RoundRobinWriter).weights)
+
+	// an explicit 0 is kept, an unset value takes the default
+	conf = config.NewSubscriber()
+	_, err = toml.Decode(`
+[[subscriptions]]
+  database = "db0"
+  name = "sub0"
+  min-weight = 0.0
+  weight-decay = 0.0
+[[subscriptions]]
+  database = "db0"
+  name = "sub1"
+`, &conf)
+	assert.NoError(t, err)
+	assert.NoError(t, conf.Validate())
+	sc = conf.Subscription("db0", "rp0", "sub0")
+	assert.Equal(t, 0.0, *sc.MinWeight)
+	assert.Equal(t, 0.0, *sc.WeightDecay)
+	sc = conf.Subscription("db0", "rp0", "sub1")
+	assert.Equal(t, config.DefaultMinWeight, *sc.MinWeight)
+	assert.Equal(t, config.DefaultWeightDecay, *sc.WeightDecay)
+
+	minWeight := 1.5
+	conf.Subscriptions[0].MinWeight = &minWeight
+	assert.EqualError(t, conf.Validate(), "subscriber min-weight 1.5 must be between 0 and 1")
+}
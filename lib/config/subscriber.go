@@ -17,8 +17,15 @@ limitations under the License.
 package config
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/influxdata/influxdb/toml"
@@ -27,8 +34,177 @@ import (
 const (
 	DefaultHTTPTimeout = 30 * time.Second // 30 seconds
 	DefaultBufferSize  = 100              // channel size 100
+	DefaultUserHeader  = "X-OpenGemini-User"
+
+	DefaultNodeIDHeader = "X-OpenGemini-Node-Id"
+
+	DefaultShutdownTimeout      = 10 * time.Second
+	DefaultContentType          = "text/plain; charset=utf-8"
+	MsgpackContentType          = "application/msgpack"
+	DefaultCreateQuery          = "CREATE DATABASE {db}"
+	DefaultSlowEnqueueThreshold = time.Second
+	DefaultTooLargeCooldown     = time.Minute
+	DefaultSRVRefreshInterval   = 30 * time.Second
+
+	DefaultWeightDecay = 0.9
+	DefaultMinWeight   = 0.1
+
+	DefaultStatsdPrefix          = "opengemini.subscriber"
+	DefaultMetricsExportInterval = 10 * time.Second
+
+	DefaultObjectStoreKeyTemplate   = "{db}/{rp}/{time}-{node}-{seq}.lp"
+	DefaultObjectStoreFlushSize     = 8 * 1024 * 1024
+	DefaultObjectStoreFlushInterval = time.Minute
 )
 
+// SubscriptionConfig holds the settings that only apply to the subscription db.rp.name
+type SubscriptionConfig struct {
+	Database        string `toml:"database"`
+	RetentionPolicy string `toml:"retention-policy"`
+	Name            string `toml:"name"`
+	// ForwardUser indicates whether to forward the user of the original write to the destinations
+	ForwardUser bool   `toml:"forward-user"`
+	UserHeader  string `toml:"user-header"`
+	// Proxy is the http, https or socks5 proxy used to reach the destinations,
+	// it overrides the HTTP_PROXY/HTTPS_PROXY environment variables
+	Proxy string `toml:"proxy"`
+	// the tag keys and field keys removed before forwarding, an empty allow list allows all the keys
+	AllowTags   []string `toml:"allow-tags"`
+	DenyTags    []string `toml:"deny-tags"`
+	AllowFields []string `toml:"allow-fields"`
+	DenyFields  []string `toml:"deny-fields"`
+	// WriteBufferSize and WriteConcurrency override the global ones for this subscription if they are positive
+	WriteBufferSize  int `toml:"write-buffer-size"`
+	WriteConcurrency int `toml:"write-concurrency"`
+	// InjectTags are the static tags in key=value form added to each forwarded point, e.g. ["source=clusterA"],
+	// a tag already in the point is kept as is
+	InjectTags []string `toml:"inject-tags"`
+	// SampleRate is the fraction of the points forwarded, zero forwards all the points.
+	// SampleMode is "series" (the default) to sample by the hash of the series key so that
+	// a series is forwarded as a whole, or "random" to sample each point independently
+	SampleRate float64 `toml:"sample-rate"`
+	SampleMode string  `toml:"sample-mode"`
+	// MaxAge drops the points whose timestamp is older than it relative to now, e.g. during the catch-up
+	// after an outage, zero forwards the points of any age
+	MaxAge toml.Duration `toml:"max-age"`
+	// MaxAgeSkew is the clock skew between the nodes tolerated by MaxAge, it is added to the window so that
+	// the points stamped by a node whose clock lags behind are not dropped as stale
+	MaxAgeSkew toml.Duration `toml:"max-age-skew"`
+	// Predicate forwards only the points whose field or tag compares to a value, in "key op value" form,
+	// e.g. status == "error" or value > 10. the operators are ==, !=, >, >=, < and <=, empty forwards all the points
+	Predicate string `toml:"predicate"`
+	// WriteMethod is the http method of the writes, one of POST (the default), PUT and PATCH.
+	// it is overridden by the method query parameter of a destination
+	WriteMethod string `toml:"write-method"`
+	// NonIdempotent declares that a write can not be applied twice, e.g. counters expressed as deltas.
+	// a failed write of such a subscription is never retried on another destination, it goes to the
+	// write failure hooks at once. a write rejected with 404 is still retried after create-query, as
+	// nothing has been written
+	NonIdempotent bool `toml:"non-idempotent"`
+	// AdaptiveWeights lowers the share of the writes of an ANY mode subscription sent to a destination as its error
+	// rate rises, down to MinWeight of an equal share, and restores it as the errors subside. WeightDecay is the
+	// fraction of the error rate kept at each write to the destination, the closer to 1 the slower the weights adapt.
+	// they are pointers so that an explicit 0 is told apart from an unset value, which takes the default
+	AdaptiveWeights bool     `toml:"adaptive-weights"`
+	WeightDecay     *float64 `toml:"weight-decay"`
+	MinWeight       *float64 `toml:"min-weight"`
+	// GzipMinSize is the size in bytes of the smallest write compressed if gzip is enabled, the smaller writes
+	// are sent uncompressed as compressing them costs more than it saves. zero compresses all the writes
+	GzipMinSize int `toml:"gzip-min-size"`
+}
+
+func NewSubscriptionConfig() SubscriptionConfig {
+	weightDecay, minWeight := DefaultWeightDecay, DefaultMinWeight
+	return SubscriptionConfig{
+		UserHeader:  DefaultUserHeader,
+		WeightDecay: &weightDecay,
+		MinWeight:   &minWeight,
+	}
+}
+
+// ObjectStoreConfig holds the settings of the s3:// destinations, e.g. s3://bucket/prefix,
+// which archive the line protocol as objects in an S3 compatible object store
+type ObjectStoreConfig struct {
+	Endpoint string `toml:"endpoint"`
+	// AccessKey and SecretKey default to the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables
+	AccessKey string `toml:"access-key"`
+	SecretKey string `toml:"secret-key"`
+	// KeyTemplate is the key of the objects under the prefix of the destination, {db}, {rp}, {time} (the UTC time
+	// of the first write in the object), {node} (the hostname) and {seq} (the sequence number of the object) are replaced
+	KeyTemplate string `toml:"key-template"`
+	// an object is uploaded once it reaches FlushSize bytes or FlushInterval after its first write
+	FlushSize     int           `toml:"flush-size"`
+	FlushInterval toml.Duration `toml:"flush-interval"`
+}
+
+func NewObjectStoreConfig() ObjectStoreConfig {
+	return ObjectStoreConfig{
+		KeyTemplate:   DefaultObjectStoreKeyTemplate,
+		FlushSize:     DefaultObjectStoreFlushSize,
+		FlushInterval: toml.Duration(DefaultObjectStoreFlushInterval),
+	}
+}
+
+// DestinationConfig holds the settings that only apply to the destination URL on this node
+type DestinationConfig struct {
+	URL string `toml:"url"`
+	// LocalAddr is the local ip the connections to the destination are bound to, so that the traffic
+	// egresses on a specific interface of a multi-homed node, empty lets the system choose
+	LocalAddr string `toml:"local-addr"`
+	// WebhookTemplate is the JSON template each point is rendered with for a webhook:// or webhooks:// destination,
+	// empty renders the measurement, tags, fields and time of the point
+	WebhookTemplate string `toml:"webhook-template"`
+	// MaxPoints is the maximum number of points in each request to the destination, a larger write
+	// is sent in several requests. zero means no limit
+	MaxPoints int `toml:"max-points"`
+	// SensitiveHeaders are the names of the Headers whose values are redacted in the logs
+	SensitiveHeaders []string `toml:"sensitive-headers"`
+	// Headers are the static headers sent with every request to the destination, e.g. X-Tenant,
+	// the headers set by the subscriber itself such as Content-Type take precedence
+	Headers map[string]string `toml:"headers"`
+}
+
+// RedactedHeaders returns the Headers with the values of the SensitiveHeaders replaced, for logging
+func (dc DestinationConfig) RedactedHeaders() map[string]string {
+	redacted := make(map[string]string, len(dc.Headers))
+	for name, value := range dc.Headers {
+		redacted[name] = value
+		for _, sensitive := range dc.SensitiveHeaders {
+			if strings.EqualFold(name, sensitive) {
+				redacted[name] = "xxxxx"
+				break
+			}
+		}
+	}
+	return redacted
+}
+
+// redactedDestinations returns copies of dcs with the values of the sensitive headers replaced, for showing the configs
+func redactedDestinations(dcs []DestinationConfig) []DestinationConfig {
+	redacted := make([]DestinationConfig, 0, len(dcs))
+	for _, dc := range dcs {
+		if len(dc.Headers) > 0 {
+			dc.Headers = dc.RedactedHeaders()
+		}
+		redacted = append(redacted, dc)
+	}
+	return redacted
+}
+
+// redactedSubscriptions returns copies of scs with the password of the proxy replaced, for showing the configs
+func redactedSubscriptions(scs []SubscriptionConfig) []SubscriptionConfig {
+	redacted := make([]SubscriptionConfig, 0, len(scs))
+	for _, sc := range scs {
+		if sc.Proxy != "" {
+			if u, err := url.Parse(sc.Proxy); err == nil {
+				sc.Proxy = u.Redacted()
+			}
+		}
+		redacted = append(redacted, sc)
+	}
+	return redacted
+}
+
 type Subscriber struct {
 	Enabled            bool          `toml:"enabled"`
 	HTTPTimeout        toml.Duration `toml:"http-timeout"`
@@ -36,6 +212,97 @@ type Subscriber struct {
 	HttpsCertificate   string        `toml:"https-certificate"`
 	WriteBufferSize    int           `toml:"write-buffer-size"`
 	WriteConcurrency   int           `toml:"write-concurrency"`
+	// WriteBufferFullTimeout is the maximum time to wait for a full write buffer before the write request is dropped,
+	// zero drops it immediately
+	WriteBufferFullTimeout toml.Duration `toml:"write-buffer-full-timeout"`
+	// SlowEnqueueThreshold is the wait for a full write buffer above which a warning is logged, zero disables it
+	SlowEnqueueThreshold toml.Duration `toml:"slow-enqueue-threshold"`
+	// IdleTimeout stops the workers of a subscription and closes its idle connections once no write is forwarded
+	// to it for the timeout, they are started again by the next write. zero keeps them running
+	IdleTimeout toml.Duration `toml:"idle-timeout"`
+	// Warmup indicates whether to set up the connections to the destinations when a subscription is started,
+	// so that the first write does not pay for the connection setup
+	Warmup bool `toml:"warmup"`
+	// AnyFailover indicates whether a write request of an ANY mode subscription that fails is sent to
+	// the next destination in rotation, until one of them accepts it, instead of being dropped
+	AnyFailover bool `toml:"any-failover"`
+	// RetryBudget is the ratio of the failover retries to the successful writes allowed, so that the retries
+	// stop instead of amplifying the load during a broad outage, zero means no limit
+	RetryBudget float64 `toml:"retry-budget"`
+	// FailoverBackoff is the wait before a failed write request is sent to the next destination, doubled on each
+	// retry, so that a slow or overloaded destination gets a chance to recover. connection errors, such as a refused
+	// connection, fail over immediately since the destination is down. zero fails over immediately on any error
+	FailoverBackoff toml.Duration `toml:"failover-backoff"`
+	// HealthCheckInterval is the interval to ping the destinations of ANY mode subscriptions,
+	// unhealthy destinations are removed from the rotation until they recover, zero (the default) disables it
+	HealthCheckInterval toml.Duration `toml:"health-check-interval"`
+	// ShutdownTimeout is the maximum time to forward the buffered write requests when the server shuts down,
+	// and when a subscription is removed or modified
+	ShutdownTimeout toml.Duration `toml:"shutdown-timeout"`
+	// ContentType is the Content-Type header of the line protocol forwarded over http,
+	// the writes are encoded with msgpack instead of line protocol if it is MsgpackContentType
+	ContentType string `toml:"content-type"`
+	// Gzip indicates whether to compress the line protocol forwarded over http
+	Gzip bool `toml:"gzip"`
+	// ConnMaxLifetime is the duration after which the keep-alive connections to the destinations are recycled,
+	// so that the traffic is redistributed behind a load balancer, zero means no limit
+	ConnMaxLifetime toml.Duration `toml:"conn-max-lifetime"`
+	// TooLargeCooldown is how long the writes to a destination are split in advance to the size it accepted,
+	// after it rejects a larger write with 413 Payload Too Large, zero only splits the rejected writes
+	TooLargeCooldown toml.Duration `toml:"too-large-cooldown"`
+	// SRVRefreshInterval is the interval to resolve the http+srv and https+srv destinations again, the writers
+	// are recreated when the targets of the SRV records change. zero only resolves them when a writer is created
+	SRVRefreshInterval toml.Duration `toml:"srv-refresh-interval"`
+	// MinDestinationVersion is the lowest version of openGemini the http and https destinations must report
+	// in the ping response when a writer is created, the writer of a subscription with an older destination is
+	// not created. a destination that can not be pinged or reports no version is only logged, empty disables the check
+	MinDestinationVersion string `toml:"min-destination-version"`
+	// ReconfigureConcurrency is the number of writers created at once, and of replaced writers drained at once,
+	// when the subscriptions change. zero creates the writers one by one and drains all the replaced ones at once
+	ReconfigureConcurrency int `toml:"reconfigure-concurrency"`
+	// AllowTestDelay enables the delay query parameter of the http and https destinations, e.g.
+	// http://127.0.0.1:8086?delay=200ms, which sleeps before each write to simulate a slow network.
+	// it is meant for testing the backpressure and failover in staging, the parameter is ignored if it is not set
+	AllowTestDelay bool `toml:"allow-test-delay"`
+	// RejectLocalDestination refuses to create the writer of a subscription with a destination pointing back
+	// at the http bind address of this node, which would forward the writes again in a loop. such a destination
+	// is only warned about if it is not set
+	RejectLocalDestination bool `toml:"reject-local-destination"`
+	// MaxFanOut is the maximum number of destinations of an ALL mode subscription, zero means no limit.
+	// a warning is logged when it is exceeded, the subscription is not created if RejectAboveMaxFanOut is set
+	MaxFanOut            int  `toml:"max-fan-out"`
+	RejectAboveMaxFanOut bool `toml:"reject-above-max-fan-out"`
+	// FanOutParallelism is the maximum number of destinations an ALL mode write is sent to concurrently,
+	// the write is buffered once for all the destinations. zero buffers it once per destination instead
+	FanOutParallelism int `toml:"fan-out-parallelism"`
+	// MaxConcurrencyPerDestination limits the concurrent in-flight requests to each destination, zero means no limit.
+	// it is overridden by the maxconc query parameter of a destination
+	MaxConcurrencyPerDestination int `toml:"max-concurrency-per-destination"`
+	// CreateOnNotFound indicates whether to run CreateQuery on a destination that returns 404 for a write,
+	// then retry the write once. {db} and {rp} in CreateQuery are replaced by the quoted database and retention policy
+	CreateOnNotFound bool   `toml:"create-on-not-found"`
+	CreateQuery      string `toml:"create-query"`
+	// RecentMeasurements is the number of measurements recently forwarded tracked per subscription
+	// for debugging the routing, zero disables the tracking
+	RecentMeasurements int `toml:"recent-measurements"`
+	// RecentOverflowBucket counts the points of the measurements beyond RecentMeasurements in an overflow bucket,
+	// which has an empty measurement name, instead of evicting the least recently seen measurement, so that a high
+	// cardinality of measurements does not churn the tracked ones
+	RecentOverflowBucket bool `toml:"recent-overflow-bucket"`
+	// ForwardNodeID indicates whether to send NodeID in NodeIDHeader with every forwarded write, so that
+	// the destinations can tell which node the data comes from, an empty NodeID means the hostname
+	ForwardNodeID bool   `toml:"forward-node-id"`
+	NodeID        string `toml:"node-id"`
+	NodeIDHeader  string `toml:"node-id-header"`
+	// StatsdAddress is the udp address of the statsd server the sent, failed and dropped writes and the write latency
+	// of each subscription are pushed to every MetricsExportInterval, empty disables the push
+	StatsdAddress         string        `toml:"statsd-address"`
+	StatsdPrefix          string        `toml:"statsd-prefix"`
+	MetricsExportInterval toml.Duration `toml:"metrics-export-interval"`
+
+	Subscriptions []SubscriptionConfig `toml:"subscriptions"`
+	Destinations  []DestinationConfig  `toml:"destinations"`
+	ObjectStore   ObjectStoreConfig    `toml:"object-store"`
 }
 
 func NewSubscriber() Subscriber {
@@ -46,6 +313,17 @@ func NewSubscriber() Subscriber {
 		HttpsCertificate:   "",
 		WriteBufferSize:    DefaultBufferSize,
 		WriteConcurrency:   runtime.NumCPU() * 2,
+
+		ShutdownTimeout:       toml.Duration(DefaultShutdownTimeout),
+		ContentType:           DefaultContentType,
+		CreateQuery:           DefaultCreateQuery,
+		SlowEnqueueThreshold:  toml.Duration(DefaultSlowEnqueueThreshold),
+		TooLargeCooldown:      toml.Duration(DefaultTooLargeCooldown),
+		SRVRefreshInterval:    toml.Duration(DefaultSRVRefreshInterval),
+		NodeIDHeader:          DefaultNodeIDHeader,
+		StatsdPrefix:          DefaultStatsdPrefix,
+		MetricsExportInterval: toml.Duration(DefaultMetricsExportInterval),
+		ObjectStore:           NewObjectStoreConfig(),
 	}
 }
 
@@ -59,16 +337,252 @@ func (s Subscriber) Validate() error {
 	if s.WriteConcurrency <= 0 {
 		return errors.New("subscriber write-concurrency can not be zero or negative")
 	}
+	if s.WriteBufferFullTimeout < 0 {
+		return errors.New("subscriber write-buffer-full-timeout can not be negative")
+	}
+	if s.IdleTimeout < 0 {
+		return errors.New("subscriber idle-timeout can not be negative")
+	}
+	if s.SlowEnqueueThreshold < 0 {
+		return errors.New("subscriber slow-enqueue-threshold can not be negative")
+	}
+	if s.RetryBudget < 0 {
+		return errors.New("subscriber retry-budget can not be negative")
+	}
+	if s.FailoverBackoff < 0 {
+		return errors.New("subscriber failover-backoff can not be negative")
+	}
+	if s.HealthCheckInterval < 0 {
+		return errors.New("subscriber health-check-interval can not be negative")
+	}
+	if s.ShutdownTimeout < 0 {
+		return errors.New("subscriber shutdown-timeout can not be negative")
+	}
+	if s.TooLargeCooldown < 0 {
+		return errors.New("subscriber too-large-cooldown can not be negative")
+	}
+	if s.SRVRefreshInterval < 0 {
+		return errors.New("subscriber srv-refresh-interval can not be negative")
+	}
+	if s.MinDestinationVersion != "" {
+		if _, err := ParseVersion(s.MinDestinationVersion); err != nil {
+			return fmt.Errorf("subscriber min-destination-version %s", err)
+		}
+	}
+	if s.ConnMaxLifetime < 0 {
+		return errors.New("subscriber conn-max-lifetime can not be negative")
+	}
+	if s.MaxFanOut < 0 {
+		return errors.New("subscriber max-fan-out can not be negative")
+	}
+	if s.ReconfigureConcurrency < 0 {
+		return errors.New("subscriber reconfigure-concurrency can not be negative")
+	}
+	if s.FanOutParallelism < 0 {
+		return errors.New("subscriber fan-out-parallelism can not be negative")
+	}
+	if s.MaxConcurrencyPerDestination < 0 {
+		return errors.New("subscriber max-concurrency-per-destination can not be negative")
+	}
+	if s.RecentMeasurements < 0 {
+		return errors.New("subscriber recent-measurements can not be negative")
+	}
+	if s.StatsdAddress != "" && s.MetricsExportInterval <= 0 {
+		return errors.New("subscriber metrics-export-interval can not be zero or negative if statsd-address is set")
+	}
+	if s.CreateOnNotFound && s.CreateQuery == "" {
+		return errors.New("subscriber create-query can not be empty if create-on-not-found is enabled")
+	}
+	for _, sc := range s.Subscriptions {
+		if sc.Database == "" || sc.Name == "" {
+			return errors.New("subscriber subscriptions must specify database and name")
+		}
+		if sc.WriteBufferSize < 0 || sc.WriteConcurrency < 0 {
+			return errors.New("subscriber write-buffer-size and write-concurrency of subscriptions can not be negative")
+		}
+		for _, t := range sc.InjectTags {
+			if strings.IndexByte(t, '=') <= 0 {
+				return fmt.Errorf("subscriber inject-tags %s must be in key=value form", t)
+			}
+		}
+		if sc.WriteMethod != "" && !ValidWriteMethod(sc.WriteMethod) {
+			return fmt.Errorf("subscriber write-method %s must be POST, PUT or PATCH", sc.WriteMethod)
+		}
+		if sc.GzipMinSize < 0 {
+			return errors.New("subscriber gzip-min-size of subscriptions can not be negative")
+		}
+		if sc.MaxAge < 0 {
+			return errors.New("subscriber max-age of subscriptions can not be negative")
+		}
+		if sc.MaxAgeSkew < 0 {
+			return errors.New("subscriber max-age-skew of subscriptions can not be negative")
+		}
+		if sc.SampleRate < 0 || sc.SampleRate > 1 {
+			return fmt.Errorf("subscriber sample-rate %v must be between 0 and 1", sc.SampleRate)
+		}
+		if sc.WeightDecay != nil && (*sc.WeightDecay < 0 || *sc.WeightDecay >= 1) {
+			return fmt.Errorf("subscriber weight-decay %v must be at least 0 and less than 1", *sc.WeightDecay)
+		}
+		if sc.MinWeight != nil && (*sc.MinWeight < 0 || *sc.MinWeight > 1) {
+			return fmt.Errorf("subscriber min-weight %v must be between 0 and 1", *sc.MinWeight)
+		}
+		if sc.SampleMode != "" && sc.SampleMode != "series" && sc.SampleMode != "random" {
+			return fmt.Errorf("subscriber sample-mode %s is not supported", sc.SampleMode)
+		}
+		if sc.Proxy != "" {
+			u, err := url.Parse(sc.Proxy)
+			if err != nil {
+				return fmt.Errorf("subscriber proxy %s is invalid: %v", sc.Proxy, err)
+			}
+			if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+				return fmt.Errorf("subscriber proxy scheme %s is not supported", u.Scheme)
+			}
+		}
+	}
+	if s.ObjectStore.KeyTemplate == "" {
+		return errors.New("subscriber object-store key-template can not be empty")
+	}
+	if s.ObjectStore.FlushSize <= 0 {
+		return errors.New("subscriber object-store flush-size can not be zero or negative")
+	}
+	if s.ObjectStore.FlushInterval < 0 {
+		return errors.New("subscriber object-store flush-interval can not be negative")
+	}
+	if s.ForwardNodeID && s.NodeIDHeader == "" {
+		return errors.New("subscriber node-id-header can not be empty if forward-node-id is set")
+	}
+	for _, dc := range s.Destinations {
+		if dc.URL == "" {
+			return errors.New("subscriber destinations must specify url")
+		}
+		if dc.LocalAddr != "" && net.ParseIP(dc.LocalAddr) == nil {
+			return fmt.Errorf("subscriber local-addr %s of destination %s is not an ip", dc.LocalAddr, dc.URL)
+		}
+		for name, value := range dc.Headers {
+			if name == "" || strings.ContainsAny(name, " \t\r\n:") || strings.ContainsAny(value, "\r\n") {
+				return fmt.Errorf("subscriber header %q of destination %s is invalid", name, dc.URL)
+			}
+		}
+		if dc.MaxPoints < 0 {
+			return fmt.Errorf("subscriber max-points of destination %s can not be negative", dc.URL)
+		}
+		if dc.WebhookTemplate != "" && !json.Valid([]byte(dc.WebhookTemplate)) {
+			return fmt.Errorf("subscriber webhook-template of destination %s is not valid JSON", dc.URL)
+		}
+	}
 	return nil
 }
 
+// ValidWriteMethod reports whether method carries a body and can be used to write
+func ValidWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		return true
+	}
+	return false
+}
+
+// ParseVersion parses the major, minor and patch numbers of a version such as v1.2.0 or 1.2.0-rc1,
+// the missing minor and patch numbers are zero and the pre-release and build suffixes are ignored
+func ParseVersion(version string) ([3]int, error) {
+	var parsed [3]int
+	v := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(v, "-+ "); i >= 0 {
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) > len(parsed) {
+		return parsed, fmt.Errorf("invalid version %s", version)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return parsed, fmt.Errorf("invalid version %s", version)
+		}
+		parsed[i] = n
+	}
+	return parsed, nil
+}
+
+// Destination returns the settings of the destination url, which is matched as it is in the subscription
+func (s Subscriber) Destination(url string) DestinationConfig {
+	for _, dc := range s.Destinations {
+		if dc.URL == url {
+			return dc
+		}
+	}
+	return DestinationConfig{URL: url}
+}
+
+// Subscription returns the settings of the subscription db.rp.name,
+// an empty retention-policy in the config matches all the retention policies of the database
+func (s Subscriber) Subscription(db, rp, name string) SubscriptionConfig {
+	for _, sc := range s.Subscriptions {
+		if sc.Database == db && sc.Name == name && (sc.RetentionPolicy == "" || sc.RetentionPolicy == rp) {
+			if sc.UserHeader == "" {
+				sc.UserHeader = DefaultUserHeader
+			}
+			if sc.WeightDecay == nil {
+				weightDecay := DefaultWeightDecay
+				sc.WeightDecay = &weightDecay
+			}
+			if sc.MinWeight == nil {
+				minWeight := DefaultMinWeight
+				sc.MinWeight = &minWeight
+			}
+			return sc
+		}
+	}
+	sc := NewSubscriptionConfig()
+	sc.Database, sc.RetentionPolicy, sc.Name = db, rp, name
+	return sc
+}
+
 func (c *Subscriber) ShowConfigs() map[string]interface{} {
 	return map[string]interface{}{
-		"subscriber.enabled":              c.Enabled,
-		"subscriber.http-timeout":         c.HTTPTimeout,
-		"subscriber.insecure-skip-verify": c.InsecureSkipVerify,
-		"subscriber.https-certificate":    c.HttpsCertificate,
-		"subscriber.write-buffer-size":    c.WriteBufferSize,
-		"subscriber.write-concurrency":    c.WriteConcurrency,
+		"subscriber.enabled":                         c.Enabled,
+		"subscriber.http-timeout":                    c.HTTPTimeout,
+		"subscriber.insecure-skip-verify":            c.InsecureSkipVerify,
+		"subscriber.https-certificate":               c.HttpsCertificate,
+		"subscriber.write-buffer-size":               c.WriteBufferSize,
+		"subscriber.write-concurrency":               c.WriteConcurrency,
+		"subscriber.write-buffer-full-timeout":       c.WriteBufferFullTimeout,
+		"subscriber.slow-enqueue-threshold":          c.SlowEnqueueThreshold,
+		"subscriber.idle-timeout":                    c.IdleTimeout,
+		"subscriber.warmup":                          c.Warmup,
+		"subscriber.any-failover":                    c.AnyFailover,
+		"subscriber.retry-budget":                    c.RetryBudget,
+		"subscriber.failover-backoff":                c.FailoverBackoff,
+		"subscriber.health-check-interval":           c.HealthCheckInterval,
+		"subscriber.shutdown-timeout":                c.ShutdownTimeout,
+		"subscriber.content-type":                    c.ContentType,
+		"subscriber.gzip":                            c.Gzip,
+		"subscriber.conn-max-lifetime":               c.ConnMaxLifetime,
+		"subscriber.too-large-cooldown":              c.TooLargeCooldown,
+		"subscriber.srv-refresh-interval":            c.SRVRefreshInterval,
+		"subscriber.min-destination-version":         c.MinDestinationVersion,
+		"subscriber.max-fan-out":                     c.MaxFanOut,
+		"subscriber.reject-above-max-fan-out":        c.RejectAboveMaxFanOut,
+		"subscriber.reconfigure-concurrency":         c.ReconfigureConcurrency,
+		"subscriber.allow-test-delay":                c.AllowTestDelay,
+		"subscriber.reject-local-destination":        c.RejectLocalDestination,
+		"subscriber.fan-out-parallelism":             c.FanOutParallelism,
+		"subscriber.max-concurrency-per-destination": c.MaxConcurrencyPerDestination,
+		"subscriber.create-on-not-found":             c.CreateOnNotFound,
+		"subscriber.create-query":                    c.CreateQuery,
+		"subscriber.recent-measurements":             c.RecentMeasurements,
+		"subscriber.recent-overflow-bucket":          c.RecentOverflowBucket,
+		"subscriber.forward-node-id":                 c.ForwardNodeID,
+		"subscriber.node-id":                         c.NodeID,
+		"subscriber.node-id-header":                  c.NodeIDHeader,
+		"subscriber.statsd-address":                  c.StatsdAddress,
+		"subscriber.statsd-prefix":                   c.StatsdPrefix,
+		"subscriber.metrics-export-interval":         c.MetricsExportInterval,
+		"subscriber.subscriptions":                   redactedSubscriptions(c.Subscriptions),
+		"subscriber.destinations":                    redactedDestinations(c.Destinations),
+		"subscriber.object-store.endpoint":           c.ObjectStore.Endpoint,
+		"subscriber.object-store.key-template":       c.ObjectStore.KeyTemplate,
+		"subscriber.object-store.flush-size":         c.ObjectStore.FlushSize,
+		"subscriber.object-store.flush-interval":     c.ObjectStore.FlushInterval,
 	}
 }
@@ -337,3 +337,36 @@ func TestSuppressLogger(t *testing.T) {
 	assert.Equal(t, expErrno, logs[0].Errno, "incorrect errno")
 	assert.Equal(t, "", logs[1].Errno, "incorrect errno, exp empty")
 }
+
+func TestLoggerChild(t *testing.T) {
+	lg := logger.NewLogger(errno.ModuleUnknown)
+	assert.Same(t, lg, lg.With(zap.String("color", "red")))
+	child := lg.Child(zap.String("color", "red"))
+	assert.NotSame(t, lg, child)
+
+	var logs []*logger.SuppressLog
+	lg.GetSuppressLogger().ApplyObserver(func(log *logger.SuppressLog) {
+		logs = append(logs, log)
+	})
+	defer lg.GetSuppressLogger().ApplyObserver(nil)
+
+	// every log written from a new line spills the previous one
+	child.Warn("with color", zap.String("shape", "circle"))
+	lg.Warn("without color")
+	child.Child(zap.String("size", "big")).Info("with color and size")
+	lg.Info("flush")
+
+	if !assert.Equal(t, 3, len(logs)) {
+		return
+	}
+	keys := func(log *logger.SuppressLog) []string {
+		var ret []string
+		for _, f := range log.Fields {
+			ret = append(ret, f.Key)
+		}
+		return ret
+	}
+	assert.Equal(t, []string{"shape", "color"}, keys(logs[0]))
+	assert.Empty(t, keys(logs[1]))
+	assert.Equal(t, []string{"color", "size"}, keys(logs[2]))
+}
@@ -395,6 +395,8 @@ type Logger struct {
 	logger *SuppressLogger
 	node   errno.Node
 	module errno.Module
+	// fields are added to every log written through this logger, they are only set on the loggers created by Child
+	fields []zap.Field
 }
 
 var loggerPool sync.Map
@@ -420,27 +422,50 @@ func (l *Logger) With(fields ...zap.Field) *Logger {
 	return l
 }
 
+// Child returns a child logger that adds fields to every log it writes, unlike With it allocates a new logger.
+// The child shares the suppressor of l, so it does not start a new goroutine.
+func (l *Logger) Child(fields ...zap.Field) *Logger {
+	child := &Logger{
+		logger: l.logger,
+		node:   l.node,
+		module: l.module,
+		fields: make([]zap.Field, 0, len(l.fields)+len(fields)),
+	}
+	child.fields = append(child.fields, l.fields...)
+	child.fields = append(child.fields, fields...)
+	return child
+}
+
+func (l *Logger) withFields(fields []zap.Field) []zap.Field {
+	if len(l.fields) == 0 {
+		return fields
+	}
+	all := make([]zap.Field, 0, len(fields)+len(l.fields))
+	all = append(all, fields...)
+	return append(all, l.fields...)
+}
+
 func (l *Logger) SetModule(m errno.Module) {
 	l.module = m
 }
 
 func (l *Logger) Error(msg string, fields ...zap.Field) {
-	l.logger.Error(l.node, l.module, msg, fields...)
+	l.logger.Error(l.node, l.module, msg, l.withFields(fields)...)
 }
 
 func (l *Logger) Info(msg string, fields ...zap.Field) {
-	l.logger.Info(msg, fields...)
+	l.logger.Info(msg, l.withFields(fields)...)
 }
 
 func (l *Logger) Warn(msg string, fields ...zap.Field) {
-	l.logger.Warn(msg, fields...)
+	l.logger.Warn(msg, l.withFields(fields)...)
 }
 
 func (l *Logger) Debug(msg string, fields ...zap.Field) {
 	if level > zapcore.DebugLevel {
 		return
 	}
-	l.logger.Debug(msg, fields...)
+	l.logger.Debug(msg, l.withFields(fields)...)
 }
 
 func (l *Logger) GetZapLogger() *zap.Logger {
@@ -2346,13 +2346,22 @@ func (c *Client) DeleteMetaNode(id uint64) error {
 	return c.retryUntilExec(proto2.Command_DeleteMetaNodeCommand, proto2.E_DeleteMetaNodeCommand_Command, cmd)
 }
 
-// validateURL returns an error if the URL does not have a port or uses a scheme other than HTTP.
+// validateURL returns an error if the URL does not have a port or uses a scheme other than HTTP,
+// an s3 URL must have a bucket as its host, and a webhook or webhooks URL must have a host.
+// an http+srv or https+srv URL must have the SRV name to resolve the destinations from as its host.
 func validateURL(input string) error {
 	u, err := url.Parse(input)
 	if err != nil {
 		return errors.New("invalid url")
 	}
 
+	if u.Scheme == "s3" || u.Scheme == "webhook" || u.Scheme == "webhooks" || u.Scheme == "http+srv" || u.Scheme == "https+srv" {
+		if u.Host == "" {
+			return errors.New("invalid url")
+		}
+		return nil
+	}
+
 	if u.Scheme != "http" && u.Scheme != "https" {
 		return errors.New("invalid url")
 	}
@@ -2385,6 +2394,10 @@ func (c *Client) CreateSubscription(database, rp, name, mode string, destination
 		if err := validateURL(destination); err != nil {
 			return fmt.Errorf("invalid url %s", destination)
 		}
+		// neither an object store bucket nor a generic webhook serves /ping, and an SRV name is not a server
+		if strings.HasPrefix(destination, "s3://") || strings.HasPrefix(destination, "webhook") || strings.Contains(destination, "+srv://") {
+			continue
+		}
 		if err := pingServer(destination); err != nil {
 			return fmt.Errorf("fail to ping %s", destination)
 		}
@@ -1637,3 +1637,23 @@ func TestGetAliveReadNode(t *testing.T) {
 		t.Fatalf("get alive readNodes failed")
 	}
 }
+
+func TestValidateURL(t *testing.T) {
+	for url, valid := range map[string]bool{
+		"http://127.0.0.1:8086":         true,
+		"https://127.0.0.1:8086":        true,
+		"http://127.0.0.1":              false,
+		"udp://127.0.0.1:8086":          false,
+		"s3://bucket/prefix":            true,
+		"s3:///prefix":                  false,
+		"webhook://hooks.local/events":  true,
+		"webhooks://hooks.local:8443/e": true,
+		"webhook:///events":             false,
+		"http+srv://_influx._tcp.local": true,
+		"https+srv://_db.example.com/x": true,
+		"http+srv:///events":            false,
+	} {
+		err := validateURL(url)
+		assert.Equal(t, valid, err == nil, url)
+	}
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2023 Huawei Cloud Computing Technologies Co., Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statistics
+
+import (
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// SubscriberStats keeps statistics related to a destination of a subscription
+type SubscriberStats struct {
+	LastWriteSuccess int64 // unix nano timestamp of the last successful write
+	WriteBytes       int64 // bytes of the line protocol before compression
+	WireBytes        int64 // bytes sent on the wire after compression
+	DroppedBytes     int64 // bytes of the line protocol accepted by the destination client but never delivered
+}
+
+// SubscriptionStats keeps statistics related to a subscription
+type SubscriptionStats struct {
+	RemovedTags   int64
+	RemovedFields int64
+	DroppedPoints int64
+	DroppedStale  int64 // points older than the max age of the subscription
+	Unmatched     int64 // points not matching the predicate of the subscription
+	// write requests dropped because the write buffer is full, immediately or after waiting for the buffer
+	DroppedOnFull  int64
+	TimedOutOnFull int64
+	DroppedOnStop  int64 // write requests dropped because the writer is stopped by a reconfiguration
+	SkippedEmpty   int64 // write requests skipped because there is no line in the payload
+	// ALL mode write requests forwarded to all the destinations at once that some or all of the destinations failed
+	PartialFanOuts int64
+	FailedFanOuts  int64
+	// the number of the writes and statements accepted and failed by the destinations, and their total nanoseconds
+	Writes       int64
+	FailedWrites int64
+	WriteNs      int64
+	// the number and the total nanoseconds of the waits for room in the full write buffer,
+	// and the histogram of the waits by EnqueueWaitBounds
+	EnqueueWaits       int64
+	EnqueueWaitNs      int64
+	EnqueueWaitBuckets [len(EnqueueWaitBounds) + 1]int64
+}
+
+// EnqueueWaitBounds are the upper bounds of the buckets of the enqueue wait histogram,
+// the last bucket counts the waits longer than all of them
+var EnqueueWaitBounds = [...]time.Duration{time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond, time.Second}
+
+var enqueueWaitBucketNames = [len(EnqueueWaitBounds) + 1]string{
+	"enqueueWaitLe1ms", "enqueueWaitLe10ms", "enqueueWaitLe100ms", "enqueueWaitLe1s", "enqueueWaitGt1s",
+}
+
+const (
+	StatSubscriberDatabase        = "database"
+	StatSubscriberRetentionPolicy = "retentionPolicy"
+	StatSubscriberSubscription    = "subscription"
+	StatSubscriberDestination     = "destination"
+	StatSubscriberScheme          = "scheme" // http, https, s3, webhook, ...
+	StatSubscriberHost            = "host"   // host:port of the destination without credentials or path
+
+	statSubscriberLastWriteSuccess = "lastWriteSuccessNs" // Timestamp in nanoseconds of the last successful write.
+	statSubscriberWriteBytes       = "writeBytes"         // Sum of bytes of the line protocol before compression.
+	statSubscriberWireBytes        = "wireBytes"          // Sum of bytes sent on the wire.
+	statSubscriberDroppedBytes     = "droppedBytes"       // Sum of bytes of the line protocol accepted but never delivered.
+
+	statSubscriptionRemovedTags    = "removedTags"    // Number of tags removed by the key filter.
+	statSubscriptionRemovedFields  = "removedFields"  // Number of fields removed by the key filter.
+	statSubscriptionDroppedPoints  = "droppedPoints"  // Number of points dropped by the key filter.
+	statSubscriptionDroppedStale   = "droppedStale"   // Number of points dropped as they are older than the max age.
+	statSubscriptionUnmatched      = "unmatched"      // Number of points dropped as they do not match the predicate.
+	statSubscriptionDroppedOnFull  = "droppedOnFull"  // Number of write requests dropped immediately as the buffer is full.
+	statSubscriptionTimedOutOnFull = "timedOutOnFull" // Number of write requests dropped after waiting for the full buffer.
+	statSubscriptionDroppedOnStop  = "droppedOnStop"  // Number of write requests dropped as the writer is stopped.
+	statSubscriptionSkippedEmpty   = "skippedEmpty"   // Number of write requests skipped as the payload is empty.
+	statSubscriptionPartialFanOuts = "partialFanOuts" // Number of fan-out write requests failed by some destinations.
+	statSubscriptionFailedFanOuts  = "failedFanOuts"  // Number of fan-out write requests failed by all destinations.
+	statSubscriptionWrites         = "writes"         // Number of writes accepted by a destination.
+	statSubscriptionFailedWrites   = "failedWrites"   // Number of writes a destination failed to accept.
+	statSubscriptionWriteNs        = "writeNs"        // Sum of nanoseconds of the writes to the destinations.
+	statSubscriptionEnqueueWaits   = "enqueueWaits"   // Number of waits for room in the full buffer.
+	statSubscriptionEnqueueWaitNs  = "enqueueWaitNs"  // Sum of nanoseconds waited for room in the full buffer.
+)
+
+var SubscriberTagMap map[string]string
+var SubscriberStatisticsName = "subscriber"
+var SubscriptionStatisticsName = "subscription"
+
+func NewSubscriberStats() *SubscriberStats {
+	return &SubscriberStats{}
+}
+
+func NewSubscriptionStats() *SubscriptionStats {
+	return &SubscriptionStats{}
+}
+
+func InitSubscriberStatistics(tags map[string]string) {
+	SubscriberTagMap = tags
+}
+
+func (s *SubscriberStats) SetLastWriteSuccess(ts int64) {
+	atomic.StoreInt64(&s.LastWriteSuccess, ts)
+}
+
+func (s *SubscriberStats) AddBytes(write, wire int64) {
+	atomic.AddInt64(&s.WriteBytes, write)
+	atomic.AddInt64(&s.WireBytes, wire)
+}
+
+// AddWrite records a write to a destination that took d and failed with err, nil if it succeeded
+func (s *SubscriptionStats) AddWrite(d time.Duration, err error) {
+	if err != nil {
+		atomic.AddInt64(&s.FailedWrites, 1)
+	} else {
+		atomic.AddInt64(&s.Writes, 1)
+	}
+	atomic.AddInt64(&s.WriteNs, int64(d))
+}
+
+// AddEnqueueWait records a wait of d for room in the full write buffer
+func (s *SubscriptionStats) AddEnqueueWait(d time.Duration) {
+	atomic.AddInt64(&s.EnqueueWaits, 1)
+	atomic.AddInt64(&s.EnqueueWaitNs, int64(d))
+	i := 0
+	for i < len(EnqueueWaitBounds) && d > EnqueueWaitBounds[i] {
+		i++
+	}
+	atomic.AddInt64(&s.EnqueueWaitBuckets[i], 1)
+}
+
+// Reset zeroes the byte counters and returns their values before the reset, the last write success is kept.
+// each counter is swapped atomically, so a concurrent update is counted either before or after the reset
+func (s *SubscriberStats) Reset() SubscriberStats {
+	return SubscriberStats{
+		LastWriteSuccess: atomic.LoadInt64(&s.LastWriteSuccess),
+		WriteBytes:       atomic.SwapInt64(&s.WriteBytes, 0),
+		WireBytes:        atomic.SwapInt64(&s.WireBytes, 0),
+		DroppedBytes:     atomic.SwapInt64(&s.DroppedBytes, 0),
+	}
+}
+
+// Reset zeroes the counters and returns their values before the reset,
+// each counter is swapped atomically, so a concurrent update is counted either before or after the reset
+func (s *SubscriptionStats) Reset() SubscriptionStats {
+	old := SubscriptionStats{
+		RemovedTags:    atomic.SwapInt64(&s.RemovedTags, 0),
+		RemovedFields:  atomic.SwapInt64(&s.RemovedFields, 0),
+		DroppedPoints:  atomic.SwapInt64(&s.DroppedPoints, 0),
+		DroppedStale:   atomic.SwapInt64(&s.DroppedStale, 0),
+		Unmatched:      atomic.SwapInt64(&s.Unmatched, 0),
+		DroppedOnFull:  atomic.SwapInt64(&s.DroppedOnFull, 0),
+		TimedOutOnFull: atomic.SwapInt64(&s.TimedOutOnFull, 0),
+		DroppedOnStop:  atomic.SwapInt64(&s.DroppedOnStop, 0),
+		SkippedEmpty:   atomic.SwapInt64(&s.SkippedEmpty, 0),
+		PartialFanOuts: atomic.SwapInt64(&s.PartialFanOuts, 0),
+		FailedFanOuts:  atomic.SwapInt64(&s.FailedFanOuts, 0),
+		Writes:         atomic.SwapInt64(&s.Writes, 0),
+		FailedWrites:   atomic.SwapInt64(&s.FailedWrites, 0),
+		WriteNs:        atomic.SwapInt64(&s.WriteNs, 0),
+		EnqueueWaits:   atomic.SwapInt64(&s.EnqueueWaits, 0),
+		EnqueueWaitNs:  atomic.SwapInt64(&s.EnqueueWaitNs, 0),
+	}
+	for i := range s.EnqueueWaitBuckets {
+		old.EnqueueWaitBuckets[i] = atomic.SwapInt64(&s.EnqueueWaitBuckets[i], 0)
+	}
+	return old
+}
+
+// destinationLabels returns the scheme and host:port of dest for slicing the statistics by transport,
+// and dest with its password redacted. dest is returned as it is if it is not a valid url
+func destinationLabels(dest string) (scheme, host, redacted string) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "", "", dest
+	}
+	return u.Scheme, u.Host, u.Redacted()
+}
+
+// CollectSubscriberStatistics appends the statistics of the destination dest of subscription db.rp.sub to buffer
+func CollectSubscriberStatistics(buffer []byte, db, rp, sub, dest string, stats *SubscriberStats) []byte {
+	tagMap := make(map[string]string)
+	AllocTagMap(tagMap, SubscriberTagMap)
+	tagMap[StatSubscriberDatabase] = db
+	tagMap[StatSubscriberRetentionPolicy] = rp
+	tagMap[StatSubscriberSubscription] = sub
+	scheme, host, redacted := destinationLabels(dest)
+	tagMap[StatSubscriberDestination] = redacted
+	tagMap[StatSubscriberScheme] = scheme
+	tagMap[StatSubscriberHost] = host
+	valueMap := map[string]interface{}{
+		statSubscriberLastWriteSuccess: atomic.LoadInt64(&stats.LastWriteSuccess),
+		statSubscriberWriteBytes:       atomic.LoadInt64(&stats.WriteBytes),
+		statSubscriberWireBytes:        atomic.LoadInt64(&stats.WireBytes),
+		statSubscriberDroppedBytes:     atomic.LoadInt64(&stats.DroppedBytes),
+	}
+
+	return AddPointToBuffer(SubscriberStatisticsName, tagMap, valueMap, buffer)
+}
+
+// CollectSubscriptionStatistics appends the statistics of subscription db.rp.sub to buffer
+func CollectSubscriptionStatistics(buffer []byte, db, rp, sub string, stats *SubscriptionStats) []byte {
+	tagMap := make(map[string]string)
+	AllocTagMap(tagMap, SubscriberTagMap)
+	tagMap[StatSubscriberDatabase] = db
+	tagMap[StatSubscriberRetentionPolicy] = rp
+	tagMap[StatSubscriberSubscription] = sub
+	valueMap := map[string]interface{}{
+		statSubscriptionRemovedTags:    atomic.LoadInt64(&stats.RemovedTags),
+		statSubscriptionRemovedFields:  atomic.LoadInt64(&stats.RemovedFields),
+		statSubscriptionDroppedPoints:  atomic.LoadInt64(&stats.DroppedPoints),
+		statSubscriptionDroppedStale:   atomic.LoadInt64(&stats.DroppedStale),
+		statSubscriptionUnmatched:      atomic.LoadInt64(&stats.Unmatched),
+		statSubscriptionDroppedOnFull:  atomic.LoadInt64(&stats.DroppedOnFull),
+		statSubscriptionTimedOutOnFull: atomic.LoadInt64(&stats.TimedOutOnFull),
+		statSubscriptionDroppedOnStop:  atomic.LoadInt64(&stats.DroppedOnStop),
+		statSubscriptionSkippedEmpty:   atomic.LoadInt64(&stats.SkippedEmpty),
+		statSubscriptionPartialFanOuts: atomic.LoadInt64(&stats.PartialFanOuts),
+		statSubscriptionFailedFanOuts:  atomic.LoadInt64(&stats.FailedFanOuts),
+		statSubscriptionWrites:         atomic.LoadInt64(&stats.Writes),
+		statSubscriptionFailedWrites:   atomic.LoadInt64(&stats.FailedWrites),
+		statSubscriptionWriteNs:        atomic.LoadInt64(&stats.WriteNs),
+		statSubscriptionEnqueueWaits:   atomic.LoadInt64(&stats.EnqueueWaits),
+		statSubscriptionEnqueueWaitNs:  atomic.LoadInt64(&stats.EnqueueWaitNs),
+	}
+	for i, name := range enqueueWaitBucketNames {
+		valueMap[name] = atomic.LoadInt64(&stats.EnqueueWaitBuckets[i])
+	}
+
+	return AddPointToBuffer(SubscriptionStatisticsName, tagMap, valueMap, buffer)
+}
@@ -98,6 +98,14 @@ type StatementExecutor struct {
 	// hostname for show configs statement
 	Hostname   string
 	SqlConfigs map[string]interface{}
+
+	// StatementForwarder forwards the executed statements that delete data to the subscriptions, nil if disabled
+	StatementForwarder StatementForwarder
+}
+
+// StatementForwarder forwards a statement executed on database db to the subscriptions of db
+type StatementForwarder interface {
+	SendStatement(db, stmt string)
 }
 
 type combinedRunState uint8
@@ -279,6 +287,9 @@ func (e *StatementExecutor) ExecuteStatement(stmt influxql.Statement, ctx *query
 			messages = append(messages, query.ReadOnlyWarning(stmt.String()))
 		}
 		_, err = e.retryExecuteStatement(stmt, ctx, seq)
+		if err == nil && e.StatementForwarder != nil {
+			e.StatementForwarder.SendStatement(ctx.Database, stmt.String())
+		}
 	case *influxql.DropSeriesStatement:
 		return meta2.ErrUnsupportCommand
 		if ctx.ReadOnly {
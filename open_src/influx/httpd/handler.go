@@ -115,7 +115,7 @@ type Route struct {
 }
 
 type SubscriberManager interface {
-	Send(db, rp string, lineProtocol []byte)
+	SendPrecision(db, rp, user, precision string, lineProtocol []byte)
 }
 
 // Handler represents an HTTP handler for the InfluxDB server.
@@ -1079,6 +1079,10 @@ func (h *Handler) serveWrite(w http.ResponseWriter, r *http.Request, user meta2.
 
 	readBlockSize := int(h.Config.ReadBlockSize)
 	rp := urlValues.Get("rp")
+	var userID string
+	if user != nil {
+		userID = user.ID()
+	}
 	for ctx.Read(readBlockSize) {
 		numPtsParse++
 		uw := influx.GetUnmarshalWork()
@@ -1102,7 +1106,7 @@ func (h *Handler) serveWrite(w http.ResponseWriter, r *http.Request, user meta2.
 			} else {
 				if h.SubscriberManager != nil {
 					// uw.ReqBuf is the line protocal
-					h.SubscriberManager.Send(db, rp, uw.ReqBuf)
+					h.SubscriberManager.SendPrecision(db, rp, userID, precision, uw.ReqBuf)
 				}
 				atomic.AddInt64(&statistics.HandlerStat.PointsWrittenOK, int64(len(rows)))
 			}